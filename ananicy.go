@@ -0,0 +1,328 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LineError reports a malformed line in a configuration file.
+type LineError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// LineErrors gathers the errors found while loading configuration files.
+type LineErrors []*LineError
+
+func (errs LineErrors) Error() string {
+	var lines []string
+	for _, e := range errs {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ananicyEntry is a line of an ananicy .rules, .types or .cgroups file.
+type ananicyEntry struct {
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Nice        *int    `json:"nice"`
+	Sched       *string `json:"sched"`
+	RTPrio      *int    `json:"rtprio"`
+	IOClass     *string `json:"ioclass"`
+	IONice      *int    `json:"ionice"`
+	OomScoreAdj *int    `json:"oom_score_adj"`
+	Cgroup      string  `json:"cgroup"`
+	CPUQuota    *int    `json:"CPUQuota"`
+	// where the entry was read
+	file string
+	line int
+}
+
+func (e *ananicyEntry) errorf(format string, a ...interface{}) *LineError {
+	return &LineError{e.file, e.line, fmt.Errorf(format, a...)}
+}
+
+func checkRange(name string, value *int, low, high int) error {
+	if value != nil && (*value < low || *value > high) {
+		return fmt.Errorf("%s %d out of range [%d, %d]", name, *value, low, high)
+	}
+	return nil
+}
+
+// settings returns the values of the entry, validated against the
+// scheduling policies.
+func (e *ananicyEntry) settings() (s Settings, err error) {
+	s.Nice, s.RTPrio, s.IONice, s.OomScoreAdj = e.Nice, e.RTPrio, e.IONice, e.OomScoreAdj
+	if e.Sched != nil {
+		class, found := CPU.Lookup(*e.Sched)
+		if !found {
+			return s, fmt.Errorf("unknown sched %q", *e.Sched)
+		}
+		s.SchedPolicy = &class
+	}
+	if e.IOClass != nil {
+		class, found := IO.Lookup(*e.IOClass)
+		if !found {
+			return s, fmt.Errorf("unknown ioclass %q", *e.IOClass)
+		}
+		s.IOClass = &class
+	}
+	for _, check := range []error{
+		checkRange("nice", s.Nice, -20, 19),
+		checkRange("rtprio", s.RTPrio, CPU.Low, CPU.High),
+		checkRange("ionice", s.IONice, IO.High, IO.Low),
+		checkRange("oom_score_adj", s.OomScoreAdj, -1000, 1000),
+	} {
+		if check != nil {
+			return s, check
+		}
+	}
+	return
+}
+
+// AnanicyCgroup is an entry of an ananicy .cgroups file.
+type AnanicyCgroup struct {
+	Name     string `json:"cgroup"`
+	CPUQuota int    `json:"CPUQuota"`
+}
+
+// AnanicyConfig holds the rules resolved from ananicy configuration files.
+type AnanicyConfig struct {
+	// Types maps type names to their resolved settings.
+	Types map[string]Settings
+	// Cgroups maps cgroup names to their definition.
+	Cgroups map[string]AnanicyCgroup
+	// Rules holds a rule per process name, in name order. Each rule gets the
+	// values of its type, overridden by its own values.
+	Rules []*Rule
+	// RuleCgroups maps rule names to the cgroup set by the rule or its type.
+	RuleCgroups map[string]string
+	// raw entries
+	types   map[string]*ananicyEntry
+	rules   []*ananicyEntry
+	cgroups []*ananicyEntry
+}
+
+// NewAnanicyConfig returns an empty AnanicyConfig.
+func NewAnanicyConfig() *AnanicyConfig {
+	return &AnanicyConfig{
+		Types:       make(map[string]Settings),
+		Cgroups:     make(map[string]AnanicyCgroup),
+		RuleCgroups: make(map[string]string),
+		types:       make(map[string]*ananicyEntry),
+	}
+}
+
+// LoadAnanicyDir loads the .cgroups, .types and .rules files found under
+// dir, then resolves the rules. Malformed lines are skipped and reported
+// together as LineErrors.
+func LoadAnanicyDir(dir string) (c *AnanicyConfig, err error) {
+	c = NewAnanicyConfig()
+	var errs LineErrors
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".cgroups", ".types", ".rules":
+			if err := c.LoadFile(path); err != nil {
+				var lineErrs LineErrors
+				if !errors.As(err, &lineErrs) {
+					return err
+				}
+				errs = append(errs, lineErrs...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if err = c.Resolve(); err != nil {
+		var lineErrs LineErrors
+		if !errors.As(err, &lineErrs) {
+			return
+		}
+		errs = append(errs, lineErrs...)
+	}
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+// LoadFile reads the entries of an ananicy file, its kind being given by
+// its extension. Call Resolve once all files are loaded.
+func (c *AnanicyConfig) LoadFile(path string) error {
+	kind := filepath.Ext(path)
+	switch kind {
+	case ".cgroups", ".types", ".rules":
+	default:
+		return fmt.Errorf("%s: unknown ananicy file kind", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var errs LineErrors
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		// drop trailing comments
+		if i := bytes.LastIndexByte(line, '}'); i >= 0 {
+			line = line[:i+1]
+		}
+		entry := &ananicyEntry{file: path, line: number}
+		if err := json.Unmarshal(line, entry); err != nil {
+			errs = append(errs, entry.errorf("%v", err))
+			continue
+		}
+		if err := c.add(kind, entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c *AnanicyConfig) add(kind string, entry *ananicyEntry) *LineError {
+	switch kind {
+	case ".cgroups":
+		if len(entry.Cgroup) == 0 {
+			return entry.errorf("missing cgroup")
+		}
+		if entry.CPUQuota != nil && (*entry.CPUQuota < 1 || *entry.CPUQuota > 100) {
+			return entry.errorf("CPUQuota %d out of range [1, 100]", *entry.CPUQuota)
+		}
+		c.cgroups = append(c.cgroups, entry)
+	case ".types":
+		if len(entry.Type) == 0 {
+			return entry.errorf("missing type")
+		}
+		if _, err := entry.settings(); err != nil {
+			return entry.errorf("%v", err)
+		}
+		c.types[entry.Type] = entry
+	case ".rules":
+		if len(entry.Name) == 0 {
+			return entry.errorf("missing name")
+		}
+		if _, err := entry.settings(); err != nil {
+			return entry.errorf("%v", err)
+		}
+		c.rules = append(c.rules, entry)
+	}
+	return nil
+}
+
+// resolveType returns the settings and cgroup of the type name.
+func (c *AnanicyConfig) resolveType(name string) (s Settings, cgroup string, err error) {
+	entry, found := c.types[name]
+	if !found {
+		return s, "", fmt.Errorf("unknown type %q", name)
+	}
+	s, _ = entry.settings()
+	return s, entry.Cgroup, nil
+}
+
+// Resolve computes the settings of the types and rules loaded so far.
+func (c *AnanicyConfig) Resolve() error {
+	var errs LineErrors
+	for _, entry := range c.cgroups {
+		quota := 0
+		if entry.CPUQuota != nil {
+			quota = *entry.CPUQuota
+		}
+		c.Cgroups[entry.Cgroup] = AnanicyCgroup{entry.Cgroup, quota}
+	}
+	for name, entry := range c.types {
+		c.Types[name], _ = entry.settings()
+	}
+	rules := make(map[string]*Rule)
+	for _, entry := range c.rules {
+		var s Settings
+		var cgroup string
+		if len(entry.Type) > 0 {
+			var err error
+			if s, cgroup, err = c.resolveType(entry.Type); err != nil {
+				errs = append(errs, entry.errorf("%v", err))
+				continue
+			}
+		}
+		own, _ := entry.settings()
+		s = s.Merge(own)
+		if len(entry.Cgroup) > 0 {
+			cgroup = entry.Cgroup
+		}
+		if len(cgroup) > 0 {
+			if _, found := c.Cgroups[cgroup]; !found {
+				errs = append(errs, entry.errorf("unknown cgroup %q", cgroup))
+				continue
+			}
+			c.RuleCgroups[entry.Name] = cgroup
+		}
+		// later entries override former ones, as ananicy does
+		rules[entry.Name] = &Rule{
+			Name:     entry.Name,
+			Matcher:  Matcher{Comm: regexp.QuoteMeta(entry.Name)},
+			Settings: s,
+		}
+	}
+	c.Rules = c.Rules[:0]
+	for _, r := range rules {
+		r.Compile()
+		c.Rules = append(c.Rules, r)
+	}
+	sort.Slice(c.Rules, func(i, j int) bool { return c.Rules[i].Name < c.Rules[j].Name })
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,119 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLoadAnanicyDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"00-default.cgroups": `{"cgroup": "cpu80", "CPUQuota": 80}`,
+		"00-default.types": `# types
+{"type": "Game", "nice": -5, "ioclass": "best-effort", "cgroup": "cpu80"}
+{"type": "BG_CPUIO", "nice": 16, "sched": "idle"}   # trailing comment`,
+		"games.rules": `
+{"name": "dosbox", "type": "Game", "nice": -3}  # own nice wins
+{"name": "bzip2", "type": "BG_CPUIO", "ioclass": "idle", "sched": "batch"}
+{"name": "wine", "type": "Emulator"}
+{"name": "steam", "cgroup": "cpu50"}
+{"name": "gzip", "nice": 30}
+{"name": "xz", "nice": 1}
+{"name": "xz", "ionice": 7}
+not json`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rulesFile := filepath.Join(dir, "games.rules")
+	c, err := LoadAnanicyDir(dir)
+	var errs LineErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("LoadAnanicyDir returned %v, want LineErrors", err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	sort.Strings(got)
+	want := []string{
+		rulesFile + `:4: unknown type "Emulator"`,
+		rulesFile + `:5: unknown cgroup "cpu50"`,
+		rulesFile + `:6: nice 30 out of range [-20, 19]`,
+		rulesFile + `:9: invalid character 'o' in literal null (expecting 'u')`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors:\n%q\nwant\n%q", got, want)
+	}
+
+	rules := make(map[string]Settings)
+	for _, r := range c.Rules {
+		rules[r.Name] = r.Settings
+	}
+	for _, tt := range []struct {
+		name   string
+		want   Settings
+		cgroup string
+	}{
+		{
+			// the values of the rule override those of its type
+			"dosbox",
+			Settings{Nice: IntPtr(-3), IOClass: IntPtr(IOPRIO_CLASS_BE)},
+			"cpu80",
+		},
+		{
+			"bzip2",
+			Settings{Nice: IntPtr(16), SchedPolicy: IntPtr(SCHED_BATCH), IOClass: IntPtr(IOPRIO_CLASS_IDLE)},
+			"",
+		},
+		{
+			// later entries replace former ones
+			"xz",
+			Settings{IONice: IntPtr(7)},
+			"",
+		},
+	} {
+		s, found := rules[tt.name]
+		if !found {
+			t.Errorf("rule %q not found", tt.name)
+			continue
+		}
+		if !reflect.DeepEqual(s, tt.want) {
+			t.Errorf("rule %q: got %v, want %v", tt.name, s, tt.want)
+		}
+		if cgroup := c.RuleCgroups[tt.name]; cgroup != tt.cgroup {
+			t.Errorf("rule %q: got cgroup %q, want %q", tt.name, cgroup, tt.cgroup)
+		}
+	}
+	for _, name := range []string{"wine", "steam", "gzip"} {
+		if _, found := rules[name]; found {
+			t.Errorf("invalid rule %q loaded", name)
+		}
+	}
+	if s := c.Types["BG_CPUIO"]; s.SchedPolicy == nil || *s.SchedPolicy != SCHED_IDLE {
+		t.Errorf("type with trailing comment: got %v", s)
+	}
+}
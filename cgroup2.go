@@ -0,0 +1,389 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNotDelegated is returned when the current process may not write into
+// a cgroup subtree.
+var ErrNotDelegated = errors.New("cgroup subtree not delegated")
+
+var (
+	cgroup2Mount     string
+	cgroup2MountErr  error
+	cgroup2MountOnce sync.Once
+)
+
+// GetCgroup2Mount returns the mount point of the cgroup v2 hierarchy, read
+// once from /proc/self/mountinfo.
+func GetCgroup2Mount() (string, error) {
+	cgroup2MountOnce.Do(func() {
+		var data []byte
+		if data, cgroup2MountErr = ioutil.ReadFile("/proc/self/mountinfo"); cgroup2MountErr != nil {
+			return
+		}
+		cgroup2Mount, cgroup2MountErr = parseCgroup2Mount(data)
+	})
+	return cgroup2Mount, cgroup2MountErr
+}
+
+// parseCgroup2Mount returns the first cgroup2 mount point found in the
+// content of a mountinfo file.
+func parseCgroup2Mount(data []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// optional fields end with a single "-", followed by the type
+		fields := strings.Fields(scanner.Text())
+		for i := 6; i < len(fields)-1; i++ {
+			if fields[i] == "-" {
+				if fields[i+1] == "cgroup2" {
+					return fields[4], nil
+				}
+				break
+			}
+		}
+	}
+	return "", errors.New("cgroup2 hierarchy not mounted")
+}
+
+// ParseUnifiedCgroupPath returns the cgroup v2 path found in the content of
+// a /proc/[pid]/cgroup file, or false.
+func ParseUnifiedCgroupPath(cgroup string) (string, bool) {
+	for _, line := range strings.Split(cgroup, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "0::") {
+			return strings.TrimPrefix(strings.TrimSpace(line), "0::"), true
+		}
+	}
+	return "", false
+}
+
+// Cgroup2 is a cgroup of the v2 hierarchy.
+type Cgroup2 struct {
+	// Path relative to the root of the hierarchy, e.g. "/user.slice".
+	Path string `json:"path"`
+	root string
+}
+
+// OpenCgroup2 returns the existing cgroup at path, relative to the root of
+// the hierarchy.
+func OpenCgroup2(cgroup string) (*Cgroup2, error) {
+	root, err := GetCgroup2Mount()
+	if err != nil {
+		return nil, err
+	}
+	c := &Cgroup2{Path: path.Clean("/" + cgroup), root: root}
+	if info, err := os.Stat(c.Dir()); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s: not a cgroup", c.Dir())
+	}
+	return c, nil
+}
+
+// Cgroup2 returns the cgroup v2 of p.
+func (p *Proc) Cgroup2() (*Cgroup2, error) {
+	cgroup := p.Cgroup[0]
+	if !p.IsLoaded(LoadCgroup) {
+		var err error
+		if cgroup, err = GetCgroup(p.Pid); err != nil {
+			return nil, err
+		}
+	}
+	unified, found := ParseUnifiedCgroupPath(cgroup)
+	if !found {
+		return nil, fmt.Errorf("pid %d: no cgroup v2 path", p.Pid)
+	}
+	return OpenCgroup2(unified)
+}
+
+// Dir returns the directory of c.
+func (c *Cgroup2) Dir() string {
+	return filepath.Join(c.root, c.Path)
+}
+
+func (c *Cgroup2) String() string {
+	return c.Path
+}
+
+// CheckDelegated returns ErrNotDelegated unless the current process may
+// create children, move processes and enable controllers in c.
+func (c *Cgroup2) CheckDelegated() error {
+	for _, name := range []string{"", "cgroup.procs", "cgroup.subtree_control"} {
+		file := filepath.Join(c.Dir(), name)
+		if err := unix.Access(file, unix.W_OK); err != nil {
+			return fmt.Errorf("%w: no write access to %s", ErrNotDelegated, file)
+		}
+	}
+	return nil
+}
+
+// wrap returns err with the file involved, translating permission errors
+// and the usual EBUSY and EOPNOTSUPP cases.
+func (c *Cgroup2) wrap(name string, err error) error {
+	file := filepath.Join(c.Dir(), name)
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("%w: %s: %v", ErrNotDelegated, file, err)
+	case errors.Is(err, unix.EBUSY):
+		return fmt.Errorf("%s: %v (cgroup holds processes and enables controllers for its children)", file, err)
+	case errors.Is(err, unix.EOPNOTSUPP):
+		return fmt.Errorf("%s: %v (threaded or invalid cgroup)", file, err)
+	case errors.Is(err, os.ErrNotExist) && strings.Contains(name, ".") && !strings.HasPrefix(name, "cgroup."):
+		return fmt.Errorf("%s: controller not enabled in the parent cgroup.subtree_control", file)
+	}
+	return err
+}
+
+// Get returns the trimmed content of the interface file name.
+func (c *Cgroup2) Get(name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.Dir(), name))
+	if err != nil {
+		return "", c.wrap(name, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Set writes value into the interface file name.
+func (c *Cgroup2) Set(name, value string) error {
+	// O_TRUNC is not supported by some interface files
+	file, err := os.OpenFile(filepath.Join(c.Dir(), name), os.O_WRONLY, 0)
+	if err != nil {
+		return c.wrap(name, err)
+	}
+	_, err = file.WriteString(value)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return c.wrap(name, err)
+	}
+	return nil
+}
+
+// Child returns the child cgroup name of c, creating it when missing. c must
+// be delegated to the current process.
+func (c *Cgroup2) Child(name string) (*Cgroup2, error) {
+	if len(name) == 0 || strings.ContainsAny(name, "/") || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid cgroup name %q", name)
+	}
+	child := &Cgroup2{Path: path.Join(c.Path, name), root: c.root}
+	if _, err := os.Stat(child.Dir()); err == nil {
+		return child, nil
+	}
+	if err := c.CheckDelegated(); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(child.Dir(), 0755); err != nil && !os.IsExist(err) {
+		return nil, c.wrap("", err)
+	}
+	return child, nil
+}
+
+// Controllers returns the controllers available in c.
+func (c *Cgroup2) Controllers() ([]string, error) {
+	value, err := c.Get("cgroup.controllers")
+	return strings.Fields(value), err
+}
+
+// EnableControllers enables controllers, e.g. "cpu", "io" and "memory", for
+// the children of c.
+func (c *Cgroup2) EnableControllers(controllers ...string) error {
+	var value []string
+	for _, name := range controllers {
+		value = append(value, "+"+name)
+	}
+	return c.Set("cgroup.subtree_control", strings.Join(value, " "))
+}
+
+// Procs returns the pids of the processes in c.
+func (c *Cgroup2) Procs() (pids []int, err error) {
+	value, err := c.Get("cgroup.procs")
+	if err != nil {
+		return
+	}
+	for _, field := range strings.Fields(value) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return
+}
+
+// AddProc moves pid, with all its threads, into c. The current process
+// needs write access to cgroup.procs of c and of the common ancestor of the
+// source and target cgroups.
+func (c *Cgroup2) AddProc(pid int) error {
+	if err := c.Set("cgroup.procs", strconv.Itoa(pid)); err != nil {
+		if errors.Is(err, unix.ESRCH) {
+			return ErrProcessGone
+		}
+		return err
+	}
+	return nil
+}
+
+// MoveToCgroup2 moves the process into c.
+func (h *ProcHandle) MoveToCgroup2(c *Cgroup2) error {
+	if err := h.Verify(); err != nil {
+		return err
+	}
+	return c.AddProc(h.Pid)
+}
+
+// maxValue formats value, or "max" when negative.
+func maxValue(value int64) string {
+	if value < 0 {
+		return "max"
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+// parseMaxValue parses value, returning -1 for "max".
+func parseMaxValue(value string) (int64, error) {
+	if value == "max" {
+		return -1, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// SetCPUWeight sets cpu.weight, in [1, 10000], 100 being the default.
+func (c *Cgroup2) SetCPUWeight(weight int) error {
+	if err := checkRange("cpu.weight", &weight, 1, 10000); err != nil {
+		return err
+	}
+	return c.Set("cpu.weight", strconv.Itoa(weight))
+}
+
+// SetCPUMax sets cpu.max, allowing quota of CPU time per period. A negative
+// quota removes the limit.
+func (c *Cgroup2) SetCPUMax(quota, period time.Duration) error {
+	if period <= 0 {
+		period = 100 * time.Millisecond
+	}
+	usec := int64(-1)
+	if quota >= 0 {
+		usec = quota.Microseconds()
+	}
+	return c.Set("cpu.max", fmt.Sprintf("%s %d", maxValue(usec), period.Microseconds()))
+}
+
+// SetIOWeight sets the default io.weight, in [1, 10000], 100 being the
+// default.
+func (c *Cgroup2) SetIOWeight(weight int) error {
+	if err := checkRange("io.weight", &weight, 1, 10000); err != nil {
+		return err
+	}
+	return c.Set("io.weight", fmt.Sprintf("default %d", weight))
+}
+
+// SetMemoryHigh sets memory.high in bytes, the throttling limit. A negative
+// value removes the limit.
+func (c *Cgroup2) SetMemoryHigh(bytes int64) error {
+	return c.Set("memory.high", maxValue(bytes))
+}
+
+// SetMemoryMax sets memory.max in bytes, the hard limit. A negative value
+// removes the limit.
+func (c *Cgroup2) SetMemoryMax(bytes int64) error {
+	return c.Set("memory.max", maxValue(bytes))
+}
+
+// CgroupLimits holds the values of the interface files of a cgroup. Limits
+// are -1 when set to "max", weights and limits are 0 when their controller
+// is not enabled.
+type CgroupLimits struct {
+	CPUWeight   int           `json:"cpu_weight"`
+	CPUQuota    time.Duration `json:"cpu_quota"`
+	CPUPeriod   time.Duration `json:"cpu_period"`
+	IOWeight    int           `json:"io_weight"`
+	MemoryHigh  int64         `json:"memory_high"`
+	MemoryMax   int64         `json:"memory_max"`
+	Controllers []string      `json:"controllers"`
+}
+
+// Limits reads back the values set in c.
+func (c *Cgroup2) Limits() (l CgroupLimits, err error) {
+	if value, err := c.Get("cgroup.subtree_control"); err == nil {
+		l.Controllers = strings.Fields(value)
+	}
+	get := func(name string) (string, bool) {
+		value, err := c.Get(name)
+		return value, err == nil
+	}
+	if value, ok := get("cpu.weight"); ok {
+		if l.CPUWeight, err = strconv.Atoi(value); err != nil {
+			return
+		}
+	}
+	if value, ok := get("cpu.max"); ok {
+		var quota, period int64
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			return l, fmt.Errorf("invalid cpu.max %q", value)
+		}
+		if quota, err = parseMaxValue(fields[0]); err != nil {
+			return
+		}
+		if period, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return
+		}
+		l.CPUQuota, l.CPUPeriod = time.Duration(quota)*time.Microsecond, time.Duration(period)*time.Microsecond
+		if quota < 0 {
+			l.CPUQuota = -1
+		}
+	}
+	if value, ok := get("io.weight"); ok {
+		// first line is "default N", device overrides follow
+		fields := strings.Fields(value)
+		if len(fields) < 2 || fields[0] != "default" {
+			return l, fmt.Errorf("invalid io.weight %q", value)
+		}
+		if l.IOWeight, err = strconv.Atoi(fields[1]); err != nil {
+			return
+		}
+	}
+	if value, ok := get("memory.high"); ok {
+		if l.MemoryHigh, err = parseMaxValue(value); err != nil {
+			return
+		}
+	}
+	if value, ok := get("memory.max"); ok {
+		if l.MemoryMax, err = parseMaxValue(value); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,205 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCgroup2Mount(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		mountinfo string
+		want      string
+		fails     bool
+	}{
+		{
+			"unified",
+			`22 1 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+26 22 0:23 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:4 - cgroup2 cgroup2 rw,nsdelegate
+`,
+			"/sys/fs/cgroup", false,
+		},
+		{
+			"hybrid, no optional field",
+			`25 22 0:22 / /sys/fs/cgroup ro,nosuid,nodev,noexec - tmpfs tmpfs ro,mode=755
+26 25 0:23 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime - cgroup2 cgroup2 rw
+27 25 0:24 / /sys/fs/cgroup/cpu rw,nosuid,nodev,noexec,relatime shared:6 - cgroup cgroup rw,cpu
+`,
+			"/sys/fs/cgroup/unified", false,
+		},
+		{
+			// a path named cgroup2 is not a type
+			"cgroup v1 only",
+			`27 25 0:24 / /cgroup2 rw,relatime shared:6 master:1 - cgroup cgroup rw,cpu
+`,
+			"", true,
+		},
+		{"empty", "", "", true},
+	} {
+		got, err := parseCgroup2Mount([]byte(tt.mountinfo))
+		if (err != nil) != tt.fails || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseUnifiedCgroupPath(t *testing.T) {
+	for _, tt := range []struct {
+		cgroup string
+		want   string
+		found  bool
+	}{
+		{"0::/user.slice/user-1000.slice/session-3.scope\n", "/user.slice/user-1000.slice/session-3.scope", true},
+		{"12:cpu,cpuacct:/user.slice\n1:name=systemd:/user.slice\n0::/user.slice\n", "/user.slice", true},
+		{"0::/\n", "/", true},
+		{"12:cpu,cpuacct:/user.slice\n1:name=systemd:/user.slice\n", "", false},
+		{"", "", false},
+	} {
+		got, found := ParseUnifiedCgroupPath(tt.cgroup)
+		if got != tt.want || found != tt.found {
+			t.Errorf("ParseUnifiedCgroupPath(%q) returned %q, %v, want %q, %v",
+				tt.cgroup, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+// fakeCgroup returns a Cgroup2 in a temporary directory holding files.
+func fakeCgroup(t *testing.T, files map[string]string) *Cgroup2 {
+	t.Helper()
+	c := &Cgroup2{Path: "/", root: t.TempDir()}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(c.Dir(), name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestCgroup2Limits(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+		want  CgroupLimits
+		fails bool
+	}{
+		{
+			"limited",
+			map[string]string{
+				"cgroup.subtree_control": "cpu io memory\n",
+				"cpu.weight":             "50\n",
+				"cpu.max":                "50000 100000\n",
+				"io.weight":              "default 200\n8:0 500\n",
+				"memory.high":            "1073741824\n",
+				"memory.max":             "2147483648\n",
+			},
+			CgroupLimits{
+				CPUWeight:   50,
+				CPUQuota:    50 * time.Millisecond,
+				CPUPeriod:   100 * time.Millisecond,
+				IOWeight:    200,
+				MemoryHigh:  1 << 30,
+				MemoryMax:   2 << 30,
+				Controllers: []string{"cpu", "io", "memory"},
+			},
+			false,
+		},
+		{
+			"unlimited",
+			map[string]string{
+				"cpu.max":     "max 100000\n",
+				"memory.high": "max\n",
+				"memory.max":  "max\n",
+			},
+			CgroupLimits{CPUQuota: -1, CPUPeriod: 100 * time.Millisecond, MemoryHigh: -1, MemoryMax: -1},
+			false,
+		},
+		{"controllers not enabled", nil, CgroupLimits{}, false},
+		{"invalid cpu.max", map[string]string{"cpu.max": "max\n"}, CgroupLimits{}, true},
+		{"invalid io.weight", map[string]string{"io.weight": "200\n"}, CgroupLimits{}, true},
+		{"invalid memory.max", map[string]string{"memory.max": "lots\n"}, CgroupLimits{}, true},
+	} {
+		got, err := fakeCgroup(t, tt.files).Limits()
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%s: Limits returned %+v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCgroup2Set(t *testing.T) {
+	c := fakeCgroup(t, map[string]string{"cpu.max": "", "memory.high": "", "io.weight": ""})
+	if err := c.SetCPUMax(20*time.Millisecond, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetMemoryHigh(-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetIOWeight(10001); err == nil {
+		t.Error("SetIOWeight(10001) succeeded")
+	}
+	for name, want := range map[string]string{"cpu.max": "20000 100000", "memory.high": "max"} {
+		if got, err := c.Get(name); err != nil || got != want {
+			t.Errorf("got %s %q, %v, want %q", name, got, err, want)
+		}
+	}
+	// files of a controller not enabled are missing
+	if err := c.SetMemoryMax(1); err == nil {
+		t.Error("SetMemoryMax succeeded without memory.max")
+	}
+}
+
+func TestCgroup2Child(t *testing.T) {
+	c := fakeCgroup(t, nil)
+	for _, name := range []string{"", "a/b", "/a", ".", ".."} {
+		if _, err := c.Child(name); err == nil {
+			t.Errorf("Child(%q) succeeded", name)
+		}
+	}
+	// existing children need no delegation
+	if err := os.Mkdir(filepath.Join(c.Dir(), "existing"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if child, err := c.Child("existing"); err != nil || child.Path != "/existing" {
+		t.Errorf("Child(%q) returned %v, %v", "existing", child, err)
+	}
+	if _, err := c.Child("new"); !errors.Is(err, ErrNotDelegated) {
+		t.Errorf("Child(%q) returned %v, want ErrNotDelegated", "new", err)
+	}
+	c = fakeCgroup(t, map[string]string{"cgroup.procs": "", "cgroup.subtree_control": ""})
+	child, err := c.Child("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(child.Dir()); err != nil || !info.IsDir() || child.Path != "/new" {
+		t.Errorf("Child(%q) returned %v, not created: %v", "new", child, err)
+	}
+}
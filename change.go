@@ -0,0 +1,100 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+)
+
+// FieldChange describes a field whose value differs between two
+// observations of the same process.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+func (c FieldChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Field, c.Old, c.New)
+}
+
+// Compare returns the changes of the audited fields from p to other, using
+// their json names.
+func (p *Proc) Compare(other *Proc) (changes []FieldChange) {
+	add := func(field string, old, new interface{}) {
+		if old != new {
+			changes = append(changes, FieldChange{field, old, new})
+		}
+	}
+	add("state", p.State, other.State)
+	add("nice", p.Nice, other.Nice)
+	add("policy", p.Policy, other.Policy)
+	add("rtprio", p.RTPrio, other.RTPrio)
+	add("ioprio_class", p.IOPrioClass, other.IOPrioClass)
+	add("ionice", p.IOPrioData, other.IOPrioData)
+	add("oom_score_adj", p.OomScoreAdj, other.OomScoreAdj)
+	add("autogroup_nice", p.AutogroupNice, other.AutogroupNice)
+	add("timerslack_ns", p.TimerSlack, other.TimerSlack)
+	add("cgroup", p.Cgroup[0], other.Cgroup[0])
+	add("num_threads", p.NumThreads, other.NumThreads)
+	return
+}
+
+// ProcChanges holds the changes of a process between two snapshots.
+type ProcChanges struct {
+	Proc    *Proc         `json:"proc"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// SnapshotChanges lists the processes started, exited and modified between
+// two snapshots.
+type SnapshotChanges struct {
+	Started  []*Proc       `json:"started"`
+	Exited   []*Proc       `json:"exited"`
+	Modified []ProcChanges `json:"modified"`
+}
+
+// CompareSnapshots returns the changes from prev to cur. A process whose
+// Pid was reused is reported as exited and started.
+func CompareSnapshots(prev, cur *Snapshot) (result SnapshotChanges) {
+	current := make(map[int]*Proc, len(cur.Procs))
+	for _, p := range cur.Procs {
+		current[p.Pid] = p
+	}
+	previous := make(map[int]*Proc, len(prev.Procs))
+	for _, p := range prev.Procs {
+		previous[p.Pid] = p
+		if q, found := current[p.Pid]; !found || !sameProcess(p, q) {
+			result.Exited = append(result.Exited, p)
+		}
+	}
+	for _, p := range cur.Procs {
+		old, found := previous[p.Pid]
+		if !found || !sameProcess(old, p) {
+			result.Started = append(result.Started, p)
+			continue
+		}
+		if changes := old.Compare(p); len(changes) > 0 {
+			result.Modified = append(result.Modified, ProcChanges{p, changes})
+		}
+	}
+	return
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,78 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcCompare(t *testing.T) {
+	old := snapshotProc(10, 100, 0, 0, 0)
+	old.State, old.Nice, old.OomScoreAdj = "S", 0, 0
+	p := *old
+	p.State, p.Nice, p.OomScoreAdj = "R", 5, 300
+	p.UTime = 42 // not audited
+	want := []FieldChange{
+		{"state", "S", "R"},
+		{"nice", 0, 5},
+		{"oom_score_adj", 0, 300},
+	}
+	if got := old.Compare(&p); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := old.Compare(old); len(got) > 0 {
+		t.Errorf("got %v comparing a process with itself", got)
+	}
+}
+
+func TestCompareSnapshots(t *testing.T) {
+	kept := snapshotProc(10, 100, 0, 0, 0)
+	niced := *kept
+	niced.Nice = 10
+	exited := snapshotProc(11, 100, 0, 0, 0)
+	started := snapshotProc(12, 300, 0, 0, 0)
+	reused := snapshotProc(13, 100, 0, 0, 0)
+	reusing := snapshotProc(13, 200, 0, 0, 0)
+	idle := snapshotProc(14, 100, 0, 0, 0)
+	prev := &Snapshot{Procs: []*Proc{kept, exited, reused, idle}}
+	cur := &Snapshot{Procs: []*Proc{&niced, started, reusing, idle}}
+
+	result := CompareSnapshots(prev, cur)
+	pids := func(procs []*Proc) (result []int) {
+		for _, p := range procs {
+			result = append(result, p.Pid)
+		}
+		return
+	}
+	if got := pids(result.Exited); !reflect.DeepEqual(got, []int{11, 13}) {
+		t.Fatalf("got exited %v, want [11 13]", got)
+	}
+	if got := pids(result.Started); !reflect.DeepEqual(got, []int{12, 13}) {
+		t.Fatalf("got started %v, want [12 13]", got)
+	}
+	// the reused pid is reported with its start times
+	if result.Exited[1] != reused || result.Started[1] != reusing {
+		t.Errorf("reused pid 13 reported as %v and %v", result.Exited[1], result.Started[1])
+	}
+	want := []ProcChanges{{&niced, []FieldChange{{"nice", 0, 10}}}}
+	if !reflect.DeepEqual(result.Modified, want) {
+		t.Errorf("got modified %v, want %v", result.Modified, want)
+	}
+}
@@ -0,0 +1,98 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// From linux/auxvec.h
+const AT_CLKTCK = 17
+
+// getClockTicks returns the number of clock ticks per second, as given by
+// sysconf(_SC_CLK_TCK).
+func getClockTicks() uint64 {
+	if data, err := ioutil.ReadFile("/proc/self/auxv"); err == nil {
+		size := int(unsafe.Sizeof(uintptr(0)))
+		word := func(b []byte) uint64 {
+			if size == 8 {
+				return nativeEndian.Uint64(b)
+			}
+			return uint64(nativeEndian.Uint32(b))
+		}
+		for i := 0; i+2*size <= len(data); i += 2 * size {
+			if word(data[i:]) == AT_CLKTCK {
+				if ticks := word(data[i+size:]); ticks > 0 {
+					return ticks
+				}
+			}
+		}
+	}
+	return 100
+}
+
+// ClockTicks returns the number of clock ticks per second used by the
+// kernel for process times.
+func ClockTicks() uint64 {
+	return userHZ
+}
+
+var (
+	bootTime     time.Time
+	bootTimeErr  error
+	bootTimeOnce sync.Once
+)
+
+// GetBootTime returns the system boot time, read once from the btime line
+// of /proc/stat.
+func GetBootTime() (time.Time, error) {
+	bootTimeOnce.Do(func() {
+		var data []byte
+		if data, bootTimeErr = ioutil.ReadFile("/proc/stat"); bootTimeErr != nil {
+			return
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "btime ") {
+				continue
+			}
+			var seconds int64
+			seconds, bootTimeErr = strconv.ParseInt(
+				strings.TrimSpace(strings.TrimPrefix(line, "btime ")), 10, 64,
+			)
+			if bootTimeErr == nil {
+				bootTime = time.Unix(seconds, 0)
+			}
+			return
+		}
+		bootTimeErr = errors.New("btime not found in /proc/stat")
+	})
+	return bootTime, bootTimeErr
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
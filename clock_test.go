@@ -0,0 +1,42 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTicksToDuration(t *testing.T) {
+	hz := ClockTicks()
+	for _, tt := range []struct {
+		ticks uint64
+		want  time.Duration
+	}{
+		{0, 0},
+		{hz, time.Second},
+		{hz + hz/2, 1500 * time.Millisecond},
+		// about 3 years at 100 Hz, overflowing ticks * time.Second
+		{1e10 * hz / 100, 1e8 * time.Second},
+	} {
+		if got := ticksToDuration(tt.ticks); got != tt.want {
+			t.Errorf("ticksToDuration(%d) = %v, want %v", tt.ticks, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Container describes the container a process runs in.
+type Container struct {
+	// Runtime is one of "podman", "docker", "lxc", "containerd", "crio", or
+	// "unknown" when only namespaces reveal the container.
+	Runtime string `json:"runtime"`
+	// ID is the container id, or name for LXC.
+	ID string `json:"id"`
+	// Namespaces lists the namespaces differing from those of pid 1, only
+	// compared when the cgroup path does not reveal the container.
+	Namespaces []string `json:"namespaces"`
+}
+
+func (c Container) String() string {
+	return fmt.Sprintf(
+		"{Runtime: %s, ID: %s, Namespaces: %s}",
+		c.Runtime, c.ID, strings.Join(c.Namespaces, ","),
+	)
+}
+
+// containerPatterns maps cgroup segment prefixes and suffixes to runtimes.
+var containerPatterns = []struct {
+	prefix, suffix, runtime string
+}{
+	{"libpod-conmon-", ".scope", ""}, // conmon runs outside the container
+	{"libpod-", ".scope", "podman"},
+	{"libpod-", "", "podman"},
+	{"docker-", ".scope", "docker"},
+	{"cri-containerd-", ".scope", "containerd"},
+	{"crio-conmon-", ".scope", ""},
+	{"crio-", ".scope", "crio"},
+	{"lxc.payload.", "", "lxc"},
+}
+
+// parentPatterns maps cgroupfs parent directories to runtimes, the child
+// segment being the container id.
+var parentPatterns = map[string]string{
+	"docker": "docker",
+	"lxc":    "lxc",
+}
+
+// ParseContainerCgroup returns the runtime and id of the container found in
+// a cgroup path, if any.
+func ParseContainerCgroup(cgroup string) (runtime, id string) {
+	segments := strings.Split(cgroup, "/")
+	for i, segment := range segments {
+		if i > 0 {
+			if r, found := parentPatterns[segments[i-1]]; found && len(segment) > 0 {
+				return r, segment
+			}
+		}
+		for _, pattern := range containerPatterns {
+			if !strings.HasPrefix(segment, pattern.prefix) ||
+				!strings.HasSuffix(segment, pattern.suffix) {
+				continue
+			}
+			if len(pattern.runtime) == 0 {
+				break
+			}
+			id = strings.TrimSuffix(strings.TrimPrefix(segment, pattern.prefix), pattern.suffix)
+			return pattern.runtime, id
+		}
+	}
+	return
+}
+
+// containerNamespaces are compared with those of pid 1. A process running
+// in a distinct pid, mount and uts namespace is deemed in a container.
+var containerNamespaces = []string{"pid", "mnt", "uts"}
+
+// GetNamespace returns the namespace of kind ns for pid, e.g.
+// "pid:[4026531836]".
+func GetNamespace(pid int, ns string) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%d/ns/%s", pid, ns))
+}
+
+// namespaceKinds lists the namespaces compared with those of pid 1.
+var namespaceKinds = []string{"cgroup", "ipc", "mnt", "net", "pid", "user", "uts"}
+
+var (
+	initNamespaces     map[string]string
+	initNamespacesErr  error
+	initNamespacesOnce sync.Once
+)
+
+// GetInitNamespaces returns the namespaces of pid 1, read once. Reading
+// them requires the privileges to inspect pid 1, usually root.
+func GetInitNamespaces() (map[string]string, error) {
+	initNamespacesOnce.Do(func() {
+		namespaces := make(map[string]string, len(namespaceKinds))
+		for _, ns := range namespaceKinds {
+			value, err := GetNamespace(1, ns)
+			if err != nil {
+				if os.IsNotExist(err) {
+					// namespace not supported by the kernel
+					continue
+				}
+				initNamespacesErr = fmt.Errorf("namespaces of pid 1: %w", err)
+				return
+			}
+			namespaces[ns] = value
+		}
+		initNamespaces = namespaces
+	})
+	return initNamespaces, initNamespacesErr
+}
+
+// namespaceDiff returns the namespaces of pid differing from pid 1.
+func namespaceDiff(pid int) (result []string, err error) {
+	initial, err := GetInitNamespaces()
+	if err != nil {
+		return
+	}
+	for _, ns := range namespaceKinds {
+		value, found := initial[ns]
+		if !found {
+			continue
+		}
+		if own, err := GetNamespace(pid, ns); err == nil && own != value {
+			result = append(result, ns)
+		}
+	}
+	return
+}
+
+// Container returns the container the process runs in, detected from its
+// cgroup path or, failing that, from its namespaces. The boolean is false
+// when the process does not run in a container. The error reports that the
+// namespaces could not be compared, e.g. without the privileges to inspect
+// pid 1; the result then relies on the cgroup path only.
+func (p *Proc) Container() (c Container, found bool, err error) {
+	for _, line := range strings.Split(p.Cgroup[0], "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if c.Runtime, c.ID = ParseContainerCgroup(parts[2]); len(c.Runtime) > 0 {
+			return c, true, nil
+		}
+	}
+	if c.Namespaces, err = namespaceDiff(p.Pid); err != nil {
+		return
+	}
+	count := 0
+	for _, ns := range c.Namespaces {
+		for _, wanted := range containerNamespaces {
+			if ns == wanted {
+				count++
+			}
+		}
+	}
+	if count == len(containerNamespaces) {
+		c.Runtime, found = "unknown", true
+	}
+	return
+}
+
+// InContainer reports whether the process runs in a container.
+func (p *Proc) InContainer() bool {
+	_, found, _ := p.Container()
+	return found
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
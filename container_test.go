@@ -0,0 +1,65 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import "testing"
+
+func TestParseContainerCgroup(t *testing.T) {
+	const id = "4f1c0e9b2a7d"
+	for _, tt := range []struct {
+		cgroup      string
+		runtime, id string
+	}{
+		{"/machine.slice/libpod-" + id + ".scope", "podman", id},
+		{"/machine.slice/libpod-" + id + ".scope/container", "podman", id},
+		{"/user.slice/user-1000.slice/user@1000.service/user.slice/libpod-" + id + ".scope", "podman", id},
+		// conmon monitors the container from outside
+		{"/machine.slice/libpod-conmon-" + id + ".scope", "", ""},
+		{"/system.slice/crio-conmon-" + id + ".scope", "", ""},
+		{"/libpod_parent/libpod-" + id, "podman", id},
+		{"/system.slice/docker-" + id + ".scope", "docker", id},
+		{"/docker/" + id, "docker", id},
+		{"/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + id + ".scope", "containerd", id},
+		{"/kubepods.slice/kubepods-pod1.slice/crio-" + id + ".scope", "crio", id},
+		{"/lxc.payload.web01", "lxc", "web01"},
+		{"/lxc.payload.web01/system.slice/nginx.service", "lxc", "web01"},
+		{"/lxc/web01", "lxc", "web01"},
+		{"/lxc.monitor.web01", "", ""},
+		{"/system.slice/docker.service", "", ""},
+		{"/user.slice/user-1000.slice/session-2.scope", "", ""},
+		{"/", "", ""},
+	} {
+		runtime, id := ParseContainerCgroup(tt.cgroup)
+		if runtime != tt.runtime || id != tt.id {
+			t.Errorf("ParseContainerCgroup(%q) = %q, %q, want %q, %q",
+				tt.cgroup, runtime, id, tt.runtime, tt.id)
+		}
+	}
+}
+
+func TestContainerFromCgroup(t *testing.T) {
+	p := &Proc{Cgroup: [3]string{"0::/system.slice/docker-abc.scope"}}
+	c, found, err := p.Container()
+	if err != nil || !found || c.Runtime != "docker" || c.ID != "abc" {
+		t.Errorf("Container() = %v, %v, %v", c, found, err)
+	}
+	if len(c.Namespaces) > 0 {
+		t.Errorf("namespaces compared despite cgroup match: %v", c.Namespaces)
+	}
+}
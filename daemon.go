@@ -0,0 +1,205 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DaemonStats holds the counters of a Daemon. Applied, Skipped and Failed
+// count actions, Skipped being the actions not taken, either in dry run or
+// for processes left alone after a failure. Unmatched counts the processes
+// evaluated without any matching rule.
+type DaemonStats struct {
+	Scans     uint64 `json:"scans"`
+	Execs     uint64 `json:"execs"`
+	Applied   uint64 `json:"applied"`
+	Skipped   uint64 `json:"skipped"`
+	Failed    uint64 `json:"failed"`
+	Unmatched uint64 `json:"unmatched"`
+}
+
+func (s DaemonStats) String() string {
+	return fmt.Sprintf(
+		"{Scans: %d, Execs: %d, Applied: %d, Skipped: %d, Failed: %d, Unmatched: %d}",
+		s.Scans, s.Execs, s.Applied, s.Skipped, s.Failed, s.Unmatched,
+	)
+}
+
+// DefaultScanInterval is the interval between two rescans used when the
+// Interval of a Daemon is not positive.
+const DefaultScanInterval = time.Minute
+
+// procKey identifies a process across pid reuse.
+type procKey struct {
+	Pid       int
+	StartTime uint64
+}
+
+// Daemon enforces the rules of an Engine, rescanning processes
+// periodically to catch new processes and processes whose values drifted,
+// and evaluating processes as soon as they call exec when the proc connector
+// is available.
+type Daemon struct {
+	Engine *Engine
+	Filter Filterer
+	// Interval between two rescans, DefaultScanInterval when not positive.
+	Interval time.Duration
+	// Events enables exec notifications from the proc connector, falling
+	// back to rescans only when unavailable.
+	Events bool
+	// OnAction, when set, is called for each action taken.
+	OnAction func(a Action)
+	stats    DaemonStats
+	mutex    sync.Mutex
+	// failed holds the processes for which an action failed, skipped until
+	// they call exec
+	failed map[procKey]bool
+}
+
+// NewDaemon returns a Daemon enforcing engine rules on the filtered
+// processes every interval.
+func NewDaemon(engine *Engine, filter Filterer, interval time.Duration) *Daemon {
+	return &Daemon{
+		Engine:   engine,
+		Filter:   filter,
+		Interval: interval,
+		Events:   true,
+		failed:   make(map[procKey]bool),
+	}
+}
+
+// Stats returns a copy of the counters.
+func (d *Daemon) Stats() DaemonStats {
+	return DaemonStats{
+		Scans:     atomic.LoadUint64(&d.stats.Scans),
+		Execs:     atomic.LoadUint64(&d.stats.Execs),
+		Applied:   atomic.LoadUint64(&d.stats.Applied),
+		Skipped:   atomic.LoadUint64(&d.stats.Skipped),
+		Failed:    atomic.LoadUint64(&d.stats.Failed),
+		Unmatched: atomic.LoadUint64(&d.stats.Unmatched),
+	}
+}
+
+// handle evaluates the rules for p, unless a former action on p failed.
+func (d *Daemon) handle(p *Proc) {
+	r := d.Engine.Match(p)
+	if r == nil {
+		atomic.AddUint64(&d.stats.Unmatched, 1)
+		return
+	}
+	key := procKey{p.Pid, p.StartTime}
+	d.mutex.Lock()
+	failed := d.failed[key]
+	d.mutex.Unlock()
+	if failed {
+		atomic.AddUint64(&d.stats.Skipped, uint64(len(r.Settings.Plan(p))))
+		return
+	}
+	actions := d.Engine.evaluate(p, r)
+	for _, a := range actions {
+		switch {
+		case a.Err != nil:
+			atomic.AddUint64(&d.stats.Failed, 1)
+			d.mutex.Lock()
+			d.failed[key] = true
+			d.mutex.Unlock()
+		case a.Applied:
+			atomic.AddUint64(&d.stats.Applied, 1)
+		default:
+			atomic.AddUint64(&d.stats.Skipped, 1)
+		}
+		if d.OnAction != nil {
+			d.OnAction(a)
+		}
+	}
+}
+
+// Scan evaluates the rules for all the filtered processes.
+func (d *Daemon) Scan() {
+	atomic.AddUint64(&d.stats.Scans, 1)
+	seen := make(map[procKey]bool)
+	for _, p := range FilteredProcs(d.Filter, Load(d.Engine.LoadMask())) {
+		seen[procKey{p.Pid, p.StartTime}] = true
+		d.handle(p)
+	}
+	// forget the processes that exited
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for key := range d.failed {
+		if !seen[key] {
+			delete(d.failed, key)
+		}
+	}
+}
+
+// exec evaluates the rules for a process that just called exec.
+func (d *Daemon) exec(p *Proc) {
+	atomic.AddUint64(&d.stats.Execs, 1)
+	if !d.Filter.Filter(p, nil) {
+		return
+	}
+	// the new program may match another rule
+	d.mutex.Lock()
+	delete(d.failed, procKey{p.Pid, p.StartTime})
+	d.mutex.Unlock()
+	d.handle(p)
+}
+
+// Run scans processes until ctx is done.
+func (d *Daemon) Run(ctx context.Context) error {
+	var events <-chan ProcEvent
+	if d.Events {
+		if pe, err := NewProcEvents(); err == nil {
+			defer pe.Close()
+			pe.Enrich = d.Engine.LoadMask()
+			events = pe.Listen(ctx)
+		}
+	}
+	interval := d.Interval
+	if interval <= 0 {
+		interval = DefaultScanInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	d.Scan()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			d.Scan()
+		case event, ok := <-events:
+			if !ok {
+				// fall back to rescans only
+				events = nil
+				continue
+			}
+			if e, isExec := event.(ExecEvent); isExec && e.Proc != nil {
+				d.exec(e.Proc)
+			}
+		}
+	}
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
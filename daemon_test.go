@@ -0,0 +1,83 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestDaemonStats(t *testing.T) {
+	self := readSelf(t)
+	if self.Nice >= 19 {
+		t.Skipf("nice %d too high", self.Nice)
+	}
+	initProc, err := NewProcFromStat(mustReadStat(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewEngine(&Rule{
+		Name:     "self",
+		Matcher:  Matcher{Comm: regexp.QuoteMeta(self.Comm)},
+		Settings: Settings{Nice: IntPtr(self.Nice + 1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.DryRun = true
+	d := NewDaemon(engine, GetFilterer("all"), 0)
+	d.Events = false
+
+	d.handle(initProc)
+	d.handle(initProc)
+	// the action is planned only
+	d.handle(self)
+	// the action is not even planned after a failure
+	d.failed[procKey{self.Pid, self.StartTime}] = true
+	d.handle(self)
+	want := DaemonStats{Skipped: 2, Unmatched: 2}
+	if got := d.Stats(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// a zero interval falls back to DefaultScanInterval
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Run returned %v", err)
+	}
+	if scans := d.Stats().Scans; scans != 1 {
+		t.Errorf("got %d scans, want 1", scans)
+	}
+	if _, found := d.failed[procKey{self.Pid, self.StartTime}]; !found {
+		t.Errorf("pid %d forgotten while running", os.Getpid())
+	}
+}
+
+func mustReadStat(t *testing.T, pid int) string {
+	t.Helper()
+	stat, err := ReadStat(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stat
+}
@@ -0,0 +1,328 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// ProcState is the state of a process, as given by the third field of
+// /proc/[pid]/stat.
+type ProcState byte
+
+const (
+	StateUnknown     ProcState = 0
+	StateRunning     ProcState = 'R'
+	StateSleeping    ProcState = 'S'
+	StateDiskSleep   ProcState = 'D'
+	StateZombie      ProcState = 'Z'
+	StateStopped     ProcState = 'T'
+	StateTracingStop ProcState = 't'
+	StateDead        ProcState = 'X'
+	StateIdle        ProcState = 'I'
+	StateParked      ProcState = 'P'
+	StateWakeKill    ProcState = 'K'
+	StateWaking      ProcState = 'W'
+)
+
+var stateNames = map[ProcState]string{
+	StateRunning:     "running",
+	StateSleeping:    "sleeping",
+	StateDiskSleep:   "disk-sleep",
+	StateZombie:      "zombie",
+	StateStopped:     "stopped",
+	StateTracingStop: "tracing-stop",
+	StateDead:        "dead",
+	StateIdle:        "idle",
+	StateParked:      "parked",
+	StateWakeKill:    "wakekill",
+	StateWaking:      "waking",
+}
+
+// ParseState returns the ProcState for a state letter.
+func ParseState(state string) ProcState {
+	if len(state) == 1 {
+		if _, found := stateNames[ProcState(state[0])]; found {
+			return ProcState(state[0])
+		}
+	}
+	return StateUnknown
+}
+
+func (s ProcState) String() string {
+	if name, found := stateNames[s]; found {
+		return name
+	}
+	return "unknown"
+}
+
+// ProcState returns the decoded State.
+func (stat *ProcStat) ProcState() ProcState {
+	return ParseState(stat.State)
+}
+
+// kthreadd is the pid of the kernel thread daemon.
+const kthreadd = 2
+
+// ProcFlags holds the kernel flags of a process.
+type ProcFlags uint
+
+// From include/linux/sched.h
+const (
+	PF_VCPU           ProcFlags = 0x00000001
+	PF_IDLE           ProcFlags = 0x00000002
+	PF_EXITING        ProcFlags = 0x00000004
+	PF_POSTCOREDUMP   ProcFlags = 0x00000008
+	PF_IO_WORKER      ProcFlags = 0x00000010
+	PF_WQ_WORKER      ProcFlags = 0x00000020
+	PF_FORKNOEXEC     ProcFlags = 0x00000040
+	PF_MCE_PROCESS    ProcFlags = 0x00000080
+	PF_SUPERPRIV      ProcFlags = 0x00000100
+	PF_DUMPCORE       ProcFlags = 0x00000200
+	PF_SIGNALED       ProcFlags = 0x00000400
+	PF_MEMALLOC       ProcFlags = 0x00000800
+	PF_NPROC_EXCEEDED ProcFlags = 0x00001000
+	PF_USED_MATH      ProcFlags = 0x00002000
+	PF_USER_WORKER    ProcFlags = 0x00004000
+	PF_NOFREEZE       ProcFlags = 0x00008000
+	PF_KSWAPD         ProcFlags = 0x00020000
+	PF_MEMALLOC_NOFS  ProcFlags = 0x00040000
+	PF_MEMALLOC_NOIO  ProcFlags = 0x00080000
+	PF_LOCAL_THROTTLE ProcFlags = 0x00100000
+	PF_KTHREAD        ProcFlags = 0x00200000
+	PF_RANDOMIZE      ProcFlags = 0x00400000
+	PF_NO_SETAFFINITY ProcFlags = 0x04000000
+	PF_MCE_EARLY      ProcFlags = 0x08000000
+	PF_MEMALLOC_PIN   ProcFlags = 0x10000000
+	PF_SUSPEND_TASK   ProcFlags = 0x80000000
+)
+
+var flagNames = []struct {
+	flag ProcFlags
+	name string
+}{
+	{PF_VCPU, "PF_VCPU"},
+	{PF_IDLE, "PF_IDLE"},
+	{PF_EXITING, "PF_EXITING"},
+	{PF_POSTCOREDUMP, "PF_POSTCOREDUMP"},
+	{PF_IO_WORKER, "PF_IO_WORKER"},
+	{PF_WQ_WORKER, "PF_WQ_WORKER"},
+	{PF_FORKNOEXEC, "PF_FORKNOEXEC"},
+	{PF_MCE_PROCESS, "PF_MCE_PROCESS"},
+	{PF_SUPERPRIV, "PF_SUPERPRIV"},
+	{PF_DUMPCORE, "PF_DUMPCORE"},
+	{PF_SIGNALED, "PF_SIGNALED"},
+	{PF_MEMALLOC, "PF_MEMALLOC"},
+	{PF_NPROC_EXCEEDED, "PF_NPROC_EXCEEDED"},
+	{PF_USED_MATH, "PF_USED_MATH"},
+	{PF_USER_WORKER, "PF_USER_WORKER"},
+	{PF_NOFREEZE, "PF_NOFREEZE"},
+	{PF_KSWAPD, "PF_KSWAPD"},
+	{PF_MEMALLOC_NOFS, "PF_MEMALLOC_NOFS"},
+	{PF_MEMALLOC_NOIO, "PF_MEMALLOC_NOIO"},
+	{PF_LOCAL_THROTTLE, "PF_LOCAL_THROTTLE"},
+	{PF_KTHREAD, "PF_KTHREAD"},
+	{PF_RANDOMIZE, "PF_RANDOMIZE"},
+	{PF_NO_SETAFFINITY, "PF_NO_SETAFFINITY"},
+	{PF_MCE_EARLY, "PF_MCE_EARLY"},
+	{PF_MEMALLOC_PIN, "PF_MEMALLOC_PIN"},
+	{PF_SUSPEND_TASK, "PF_SUSPEND_TASK"},
+}
+
+// Has reports whether all of flag are set.
+func (f ProcFlags) Has(flag ProcFlags) bool {
+	return f&flag == flag
+}
+
+// IsKernelThread reports whether PF_KTHREAD is set.
+func (f ProcFlags) IsKernelThread() bool {
+	return f.Has(PF_KTHREAD)
+}
+
+// Names returns the names of the known flags that are set.
+func (f ProcFlags) Names() (result []string) {
+	for _, fn := range flagNames {
+		if f.Has(fn.flag) {
+			result = append(result, fn.name)
+		}
+	}
+	return
+}
+
+func (f ProcFlags) String() string {
+	return strings.Join(f.Names(), "|")
+}
+
+// ProcFlags returns the decoded Flags.
+func (stat *ProcStat) ProcFlags() ProcFlags {
+	return ProcFlags(stat.Flags)
+}
+
+var (
+	kthreaddFound bool
+	kthreaddOnce  sync.Once
+)
+
+// isKthreadd reports whether pid 2 is the kernel thread daemon, which is
+// not the case inside a pid namespace.
+func isKthreadd() bool {
+	kthreaddOnce.Do(func() {
+		var stat ProcStat
+		if err := stat.Read(kthreadd); err == nil {
+			kthreaddFound = stat.Comm == "kthreadd" && stat.ProcFlags().IsKernelThread()
+		}
+	})
+	return kthreaddFound
+}
+
+// IsKernelThread reports whether the process is a kernel thread, either
+// flagged with PF_KTHREAD or spawned by kthreadd.
+func (stat *ProcStat) IsKernelThread() bool {
+	return stat.ProcFlags().IsKernelThread() ||
+		(stat.Ppid == kthreadd && isKthreadd())
+}
+
+// SignalSet is a bitmap of signals, bit n-1 standing for signal n.
+type SignalSet uint64
+
+// Has reports whether sig belongs to the set.
+func (s SignalSet) Has(sig unix.Signal) bool {
+	return sig > 0 && sig <= 64 && s&(1<<uint(sig-1)) != 0
+}
+
+// Signals returns the signals of the set.
+func (s SignalSet) Signals() (result []unix.Signal) {
+	for sig := unix.Signal(1); sig <= 64; sig++ {
+		if s.Has(sig) {
+			result = append(result, sig)
+		}
+	}
+	return
+}
+
+// SignalName returns the name of sig, e.g. "SIGTERM" or "SIGRTMIN+2".
+func SignalName(sig unix.Signal) string {
+	if name := unix.SignalName(sig); len(name) > 0 {
+		return name
+	}
+	// glibc reserves the first two real-time signals
+	if sig >= 34 && sig <= 64 {
+		return fmt.Sprintf("SIGRTMIN+%d", sig-34)
+	}
+	return fmt.Sprintf("SIG%d", int(sig))
+}
+
+// Names returns the names of the signals of the set.
+func (s SignalSet) Names() (result []string) {
+	for _, sig := range s.Signals() {
+		result = append(result, SignalName(sig))
+	}
+	return
+}
+
+func (s SignalSet) String() string {
+	return strings.Join(s.Names(), ",")
+}
+
+// The signal fields of /proc/[pid]/stat are masked by the kernel with
+// 0x7fffffff, so that the sets returned by the following accessors never
+// hold real-time signals. Use GetSignalMasks for the full 64 bit sets.
+
+// PendingSignals returns the decoded Signal bitmap, limited to signals 1
+// to 31.
+func (stat *ProcStat) PendingSignals() SignalSet {
+	return SignalSet(stat.Signal)
+}
+
+// BlockedSignals returns the decoded Blocked bitmap, limited to signals 1
+// to 31.
+func (stat *ProcStat) BlockedSignals() SignalSet {
+	return SignalSet(stat.Blocked)
+}
+
+// IgnoredSignals returns the decoded SigIgnore bitmap, limited to signals 1
+// to 31.
+func (stat *ProcStat) IgnoredSignals() SignalSet {
+	return SignalSet(stat.SigIgnore)
+}
+
+// CaughtSignals returns the decoded SigCatch bitmap, limited to signals 1
+// to 31.
+func (stat *ProcStat) CaughtSignals() SignalSet {
+	return SignalSet(stat.SigCatch)
+}
+
+// SignalMasks holds the signal sets of a process, as found in
+// /proc/[pid]/status.
+type SignalMasks struct {
+	Pending       SignalSet `json:"sigpnd"` // pending for the thread
+	SharedPending SignalSet `json:"shdpnd"` // pending for the process
+	Blocked       SignalSet `json:"sigblk"`
+	Ignored       SignalSet `json:"sigign"`
+	Caught        SignalSet `json:"sigcgt"`
+}
+
+// GetSignalMasks returns the signal sets of pid, including real-time
+// signals.
+func GetSignalMasks(pid int) (masks SignalMasks, err error) {
+	data, err := GetResource(pid, "status")
+	if err != nil {
+		return
+	}
+	return parseSignalMasks(data)
+}
+
+// parseSignalMasks reads the hexadecimal signal sets of a status file.
+func parseSignalMasks(data []byte) (masks SignalMasks, err error) {
+	fields := map[string]*SignalSet{
+		"SigPnd:": &masks.Pending,
+		"ShdPnd:": &masks.SharedPending,
+		"SigBlk:": &masks.Blocked,
+		"SigIgn:": &masks.Ignored,
+		"SigCgt:": &masks.Caught,
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != 2 {
+			continue
+		}
+		if set, found := fields[parts[0]]; found {
+			var value uint64
+			if value, err = strconv.ParseUint(parts[1], 16, 64); err != nil {
+				return masks, fmt.Errorf("invalid %s %q", strings.TrimSuffix(parts[0], ":"), parts[1])
+			}
+			*set = SignalSet(value)
+			delete(fields, parts[0])
+		}
+	}
+	if err = scanner.Err(); err == nil && len(fields) > 0 {
+		err = fmt.Errorf("missing signal masks in status")
+	}
+	return
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,82 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseSignalMasks(t *testing.T) {
+	status := []byte("Name:\tbash\nSigQ:\t0/24001\nSigPnd:\t0000000000000000\n" +
+		"ShdPnd:\t0000000200000000\nSigBlk:\t0000000000010000\n" +
+		"SigIgn:\t0000000000384004\nSigCgt:\t000000004b813efb\n")
+	masks, err := parseSignalMasks(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := masks.SharedPending.Signals(); !reflect.DeepEqual(got, []unix.Signal{34}) {
+		t.Errorf("SharedPending = %v", got)
+	}
+	if got := masks.SharedPending.Names(); !reflect.DeepEqual(got, []string{"SIGRTMIN+0"}) {
+		t.Errorf("SharedPending names = %v", got)
+	}
+	if !masks.Blocked.Has(unix.SIGCHLD) || !masks.Ignored.Has(unix.SIGQUIT) {
+		t.Errorf("Blocked = %v, Ignored = %v", masks.Blocked, masks.Ignored)
+	}
+	if _, err := parseSignalMasks([]byte("SigPnd:\tzz\n")); err == nil {
+		t.Error("invalid mask accepted")
+	}
+	if _, err := parseSignalMasks([]byte("Name:\tbash\n")); err == nil {
+		t.Error("missing masks accepted")
+	}
+}
+
+func TestGetSignalMasks(t *testing.T) {
+	// SIGRTMIN+4 never shows in /proc/[pid]/stat
+	signal.Ignore(unix.Signal(38))
+	defer signal.Reset(unix.Signal(38))
+	masks, err := GetSignalMasks(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !masks.Ignored.Has(unix.Signal(38)) {
+		t.Errorf("Ignored = %v", masks.Ignored)
+	}
+}
+
+func TestIsKernelThread(t *testing.T) {
+	self := &ProcStat{Pid: os.Getpid(), Ppid: os.Getppid()}
+	if self.IsKernelThread() {
+		t.Error("current process is a kernel thread")
+	}
+	// a child of pid 2 is a kernel thread only when pid 2 is kthreadd
+	child := &ProcStat{Pid: 1000, Ppid: kthreadd}
+	if child.IsKernelThread() != isKthreadd() {
+		t.Errorf("child of pid 2 = %v, kthreadd = %v", child.IsKernelThread(), isKthreadd())
+	}
+	flagged := &ProcStat{Pid: 1000, Ppid: 1, Flags: uint(PF_KTHREAD)}
+	if !flagged.IsKernelThread() {
+		t.Error("PF_KTHREAD ignored")
+	}
+}
@@ -0,0 +1,328 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// From linux/connector.h and linux/cn_proc.h
+const (
+	CN_IDX_PROC = 1
+	CN_VAL_PROC = 1
+
+	PROC_CN_MCAST_LISTEN = 1
+	PROC_CN_MCAST_IGNORE = 2
+
+	PROC_EVENT_NONE     = 0x00000000
+	PROC_EVENT_FORK     = 0x00000001
+	PROC_EVENT_EXEC     = 0x00000002
+	PROC_EVENT_UID      = 0x00000004
+	PROC_EVENT_GID      = 0x00000040
+	PROC_EVENT_SID      = 0x00000080
+	PROC_EVENT_PTRACE   = 0x00000100
+	PROC_EVENT_COMM     = 0x00000200
+	PROC_EVENT_COREDUMP = 0x40000000
+	PROC_EVENT_EXIT     = 0x80000000
+)
+
+const (
+	cnMsgLen     = 20 // struct cn_msg
+	procEventLen = 16 // header of struct proc_event
+)
+
+// nativeEndian is the byte order of netlink messages and of the auxiliary
+// vector.
+var nativeEndian = getNativeEndian()
+
+func getNativeEndian() binary.ByteOrder {
+	var x uint16 = 1
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// EventHeader holds the fields common to all process events.
+type EventHeader struct {
+	CPU       uint32 `json:"cpu"`
+	Timestamp uint64 `json:"timestamp_ns"` // nanoseconds since boot
+}
+
+func (h EventHeader) Header() EventHeader { return h }
+
+// ProcEvent is implemented by ForkEvent, ExecEvent, ExitEvent, UidEvent,
+// GidEvent, CommEvent and PtraceEvent.
+type ProcEvent interface {
+	Header() EventHeader
+}
+
+type ForkEvent struct {
+	EventHeader
+	ParentPid  int `json:"parent_pid"`
+	ParentTgid int `json:"parent_tgid"`
+	ChildPid   int `json:"child_pid"`
+	ChildTgid  int `json:"child_tgid"`
+}
+
+// ExecEvent holds Proc when the events are enriched, unless the process
+// exited before it could be read.
+type ExecEvent struct {
+	EventHeader
+	Pid  int   `json:"pid"`
+	Tgid int   `json:"tgid"`
+	Proc *Proc `json:"proc,omitempty"`
+}
+
+type ExitEvent struct {
+	EventHeader
+	Pid        int `json:"pid"`
+	Tgid       int `json:"tgid"`
+	ExitCode   int `json:"exit_code"`
+	ExitSignal int `json:"exit_signal"`
+}
+
+type UidEvent struct {
+	EventHeader
+	Pid  int `json:"pid"`
+	Tgid int `json:"tgid"`
+	Ruid int `json:"ruid"`
+	Euid int `json:"euid"`
+}
+
+type GidEvent struct {
+	EventHeader
+	Pid  int `json:"pid"`
+	Tgid int `json:"tgid"`
+	Rgid int `json:"rgid"`
+	Egid int `json:"egid"`
+}
+
+type CommEvent struct {
+	EventHeader
+	Pid  int    `json:"pid"`
+	Tgid int    `json:"tgid"`
+	Comm string `json:"comm"`
+}
+
+// PtraceEvent reports an attach, or a detach when TracerPid is zero.
+type PtraceEvent struct {
+	EventHeader
+	Pid        int `json:"pid"`
+	Tgid       int `json:"tgid"`
+	TracerPid  int `json:"tracer_pid"`
+	TracerTgid int `json:"tracer_tgid"`
+}
+
+// ProcEvents receives process events from the kernel proc connector. It
+// requires CAP_NET_ADMIN.
+type ProcEvents struct {
+	// Enrich selects the fields loaded into ExecEvent.Proc. No Proc is
+	// loaded when zero.
+	Enrich LoadMask
+	fd     int
+	mutex  sync.Mutex
+	err    error
+}
+
+// NewProcEvents opens a netlink socket and subscribes to process events.
+func NewProcEvents() (pe *ProcEvents, err error) {
+	fd, err := unix.Socket(
+		unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_CONNECTOR,
+	)
+	if err != nil {
+		return
+	}
+	pe = &ProcEvents{fd: fd}
+	addr := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: CN_IDX_PROC,
+	}
+	if err = unix.Bind(fd, addr); err == nil {
+		// wake up the reading loop to check for cancellation
+		tv := unix.NsecToTimeval(int64(waitInterval))
+		err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	}
+	if err == nil {
+		err = pe.control(PROC_CN_MCAST_LISTEN)
+	}
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return
+}
+
+// control sends op to the proc connector.
+func (pe *ProcEvents) control(op uint32) error {
+	buffer := make([]byte, unix.NLMSG_HDRLEN+cnMsgLen+4)
+	// struct nlmsghdr
+	nativeEndian.PutUint32(buffer[0:], uint32(len(buffer)))
+	nativeEndian.PutUint16(buffer[4:], unix.NLMSG_DONE)
+	nativeEndian.PutUint32(buffer[12:], uint32(os.Getpid()))
+	// struct cn_msg
+	msg := buffer[unix.NLMSG_HDRLEN:]
+	nativeEndian.PutUint32(msg[0:], CN_IDX_PROC)
+	nativeEndian.PutUint32(msg[4:], CN_VAL_PROC)
+	nativeEndian.PutUint16(msg[16:], 4)
+	nativeEndian.PutUint32(msg[cnMsgLen:], op)
+	return unix.Sendto(pe.fd, buffer, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+}
+
+// Listen returns a channel delivering events until ctx is done or an error
+// occurs, as reported by Err.
+func (pe *ProcEvents) Listen(ctx context.Context) <-chan ProcEvent {
+	events := make(chan ProcEvent, 64)
+	go func() {
+		defer close(events)
+		buffer := make([]byte, os.Getpagesize())
+		for ctx.Err() == nil {
+			n, _, err := unix.Recvfrom(pe.fd, buffer, 0)
+			switch err {
+			case nil:
+			case unix.EAGAIN, unix.EINTR, unix.ENOBUFS:
+				// timeout or lost events
+				continue
+			default:
+				pe.setErr(err)
+				return
+			}
+			messages, err := syscall.ParseNetlinkMessage(buffer[:n])
+			if err != nil {
+				pe.setErr(err)
+				return
+			}
+			for _, m := range messages {
+				event := pe.parse(m.Data)
+				if event == nil {
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
+
+func (pe *ProcEvents) setErr(err error) {
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+	pe.err = err
+}
+
+// Err returns the error that stopped Listen, if any.
+func (pe *ProcEvents) Err() error {
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+	return pe.err
+}
+
+// parse returns the event held in a cn_msg, or nil for unsupported events.
+func (pe *ProcEvents) parse(data []byte) ProcEvent {
+	if len(data) < cnMsgLen+procEventLen {
+		return nil
+	}
+	if nativeEndian.Uint32(data[0:]) != CN_IDX_PROC ||
+		nativeEndian.Uint32(data[4:]) != CN_VAL_PROC {
+		return nil
+	}
+	data = data[cnMsgLen:]
+	what := nativeEndian.Uint32(data[0:])
+	header := EventHeader{
+		CPU:       nativeEndian.Uint32(data[4:]),
+		Timestamp: nativeEndian.Uint64(data[8:]),
+	}
+	data = data[procEventLen:]
+	// field returns the i-th 32 bits field of the event data
+	field := func(i int) int {
+		if len(data) < 4*(i+1) {
+			return 0
+		}
+		return int(int32(nativeEndian.Uint32(data[4*i:])))
+	}
+	switch what {
+	case PROC_EVENT_FORK:
+		return ForkEvent{header, field(0), field(1), field(2), field(3)}
+	case PROC_EVENT_EXEC:
+		event := ExecEvent{EventHeader: header, Pid: field(0), Tgid: field(1)}
+		if pe.Enrich != 0 {
+			event.Proc = enrich(event.Tgid, pe.Enrich)
+		}
+		return event
+	case PROC_EVENT_UID:
+		return UidEvent{header, field(0), field(1), field(2), field(3)}
+	case PROC_EVENT_GID:
+		return GidEvent{header, field(0), field(1), field(2), field(3)}
+	case PROC_EVENT_PTRACE:
+		return PtraceEvent{header, field(0), field(1), field(2), field(3)}
+	case PROC_EVENT_COMM:
+		var comm []byte
+		if len(data) >= 8 {
+			comm = data[8:]
+			if len(comm) > 16 {
+				comm = comm[:16]
+			}
+			if i := bytes.IndexByte(comm, 0); i >= 0 {
+				comm = comm[:i]
+			}
+		}
+		return CommEvent{header, field(0), field(1), string(comm)}
+	case PROC_EVENT_EXIT:
+		return ExitEvent{header, field(0), field(1), field(2), field(3)}
+	}
+	return nil
+}
+
+// enrich returns the Proc for pid, or nil when it cannot be read.
+func enrich(pid int, load LoadMask) *Proc {
+	stat, err := ReadStat(pid)
+	if err != nil {
+		return nil
+	}
+	p, err := NewProcFromStat(stat, load)
+	if err != nil {
+		return nil
+	}
+	return p
+}
+
+// Close unsubscribes from process events and closes the socket.
+func (pe *ProcEvents) Close() error {
+	pe.control(PROC_CN_MCAST_IGNORE)
+	return unix.Close(pe.fd)
+}
+
+func (pe *ProcEvents) String() string {
+	return fmt.Sprintf("{fd: %d, Enrich: %d}", pe.fd, pe.Enrich)
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
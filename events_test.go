@@ -0,0 +1,135 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// eventMessage returns a cn_msg holding a proc_event, in native byte order
+// as sent by the kernel, with fields as 32 bits values followed by extra.
+func eventMessage(what uint32, fields []int32, extra []byte) []byte {
+	data := make([]byte, cnMsgLen+procEventLen, cnMsgLen+procEventLen+4*len(fields)+len(extra))
+	nativeEndian.PutUint32(data[0:], CN_IDX_PROC)
+	nativeEndian.PutUint32(data[4:], CN_VAL_PROC)
+	event := data[cnMsgLen:]
+	nativeEndian.PutUint32(event[0:], what)
+	nativeEndian.PutUint32(event[4:], 3)
+	nativeEndian.PutUint64(event[8:], 123456789)
+	for _, f := range fields {
+		var field [4]byte
+		nativeEndian.PutUint32(field[:], uint32(f))
+		data = append(data, field[:]...)
+	}
+	return append(data, extra...)
+}
+
+func TestProcEventsParse(t *testing.T) {
+	header := EventHeader{CPU: 3, Timestamp: 123456789}
+	comm := func(name string) []byte {
+		b := make([]byte, 16)
+		copy(b, name)
+		return b
+	}
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want ProcEvent
+	}{
+		{
+			"fork",
+			eventMessage(PROC_EVENT_FORK, []int32{10, 10, 11, 11}, nil),
+			ForkEvent{header, 10, 10, 11, 11},
+		},
+		{
+			"exec",
+			eventMessage(PROC_EVENT_EXEC, []int32{12, 12}, nil),
+			ExecEvent{EventHeader: header, Pid: 12, Tgid: 12},
+		},
+		{
+			"exit",
+			eventMessage(PROC_EVENT_EXIT, []int32{13, 13, 256, 17}, nil),
+			ExitEvent{header, 13, 13, 256, 17},
+		},
+		{
+			"uid",
+			eventMessage(PROC_EVENT_UID, []int32{14, 14, 1000, 0}, nil),
+			UidEvent{header, 14, 14, 1000, 0},
+		},
+		{
+			"comm",
+			eventMessage(PROC_EVENT_COMM, []int32{15, 15}, comm("worker")),
+			CommEvent{header, 15, 15, "worker"},
+		},
+		{
+			"comm without nul",
+			eventMessage(PROC_EVENT_COMM, []int32{15, 15}, []byte("0123456789abcdefXYZ")),
+			CommEvent{header, 15, 15, "0123456789abcdef"},
+		},
+		{
+			"comm missing",
+			eventMessage(PROC_EVENT_COMM, []int32{15}, nil),
+			CommEvent{header, 15, 0, ""},
+		},
+		{
+			"ptrace attach",
+			eventMessage(PROC_EVENT_PTRACE, []int32{16, 16, 20, 20}, nil),
+			PtraceEvent{header, 16, 16, 20, 20},
+		},
+		{
+			"ptrace detach",
+			eventMessage(PROC_EVENT_PTRACE, []int32{16, 16, 0, 0}, nil),
+			PtraceEvent{header, 16, 16, 0, 0},
+		},
+		{
+			// missing fields are zero
+			"truncated fields",
+			eventMessage(PROC_EVENT_FORK, []int32{10, 10}, []byte{1, 2}),
+			ForkEvent{header, 10, 10, 0, 0},
+		},
+		{"truncated header", eventMessage(PROC_EVENT_FORK, nil, nil)[:cnMsgLen+8], nil},
+		{"empty", nil, nil},
+		{"unsupported event", eventMessage(PROC_EVENT_NONE, nil, nil), nil},
+		{
+			"other connector",
+			func() []byte {
+				data := eventMessage(PROC_EVENT_FORK, []int32{10, 10, 11, 11}, nil)
+				nativeEndian.PutUint32(data[0:], CN_IDX_PROC+1)
+				return data
+			}(),
+			nil,
+		},
+	} {
+		var pe ProcEvents
+		if got := pe.parse(tt.data); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcEventsEnrich(t *testing.T) {
+	pe := ProcEvents{Enrich: LoadStat}
+	pid := int32(os.Getpid())
+	event, ok := pe.parse(eventMessage(PROC_EVENT_EXEC, []int32{pid, pid}, nil)).(ExecEvent)
+	if !ok || event.Proc == nil || event.Proc.Pid != int(pid) {
+		t.Errorf("got %#v, want the Proc of pid %d", event, pid)
+	}
+}
@@ -19,8 +19,13 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package goprocfs
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+
+	"golang.org/x/sys/unix"
 )
 
 type ProcFilter struct {
@@ -42,8 +47,104 @@ type Filterer interface {
 	String() string
 }
 
-func GetFilterer(scope string) ProcFilter {
-	switch strings.ToLower(scope) {
+// filterOptions holds the settings applied by GetFilterer.
+type filterOptions struct {
+	noKernel    bool
+	noContainer bool
+}
+
+// FilterOption configures GetFilterer.
+type FilterOption func(o *filterOptions)
+
+// ExcludeKernelThreads removes kernel threads from the "system" and "all"
+// scopes.
+func ExcludeKernelThreads() FilterOption {
+	return func(o *filterOptions) {
+		o.noKernel = true
+	}
+}
+
+// ExcludeContainers removes processes running in containers from the
+// "user", "global", "system" and "all" scopes.
+func ExcludeContainers() FilterOption {
+	return func(o *filterOptions) {
+		o.noContainer = true
+	}
+}
+
+// GetFilterer returns the filter for scope, as ParseFilterer does, falling
+// back to the "user" scope when scope is invalid.
+func GetFilterer(scope string, options ...FilterOption) ProcFilter {
+	pf, err := ParseFilterer(scope, options...)
+	if err != nil {
+		pf, _ = ParseFilterer("user", options...)
+	}
+	return pf
+}
+
+// ParseFilterer returns the filter for scope, one of "user" (default),
+// "global", "system", "kernel", "container", "all", or a scope taking an
+// argument:
+//
+//   - session:ID, processes inside the login session ID
+//   - unit:NAME, processes owned by the systemd unit NAME
+//   - slice:NAME, processes inside the systemd slice NAME
+//   - uid:UID, processes owned by UID
+//   - tty:NAME, processes whose controlling terminal is /dev/NAME
+//   - descendants:PID, processes descending from PID
+//
+// It fails on unknown scopes.
+func ParseFilterer(scope string, options ...FilterOption) (pf ProcFilter, err error) {
+	var opts filterOptions
+	for _, option := range options {
+		option(&opts)
+	}
+	if i := strings.Index(scope, ":"); i >= 0 {
+		if pf, err = getArgFilterer(strings.ToLower(scope[:i]), scope[i+1:]); err != nil {
+			return
+		}
+	} else if pf, err = getFilterer(scope); err != nil {
+		return
+	}
+	if opts.noKernel && (pf.scope == "system" || pf.scope == "all") {
+		filter := pf.filter
+		pf.filter = func(p *Proc, err error) bool {
+			return filter(p, err) && !p.IsKernelThread()
+		}
+		pf.message += ", excluding kernel threads"
+	}
+	switch pf.scope {
+	case "user", "global", "system", "all":
+		if opts.noContainer {
+			filter := pf.filter
+			pf.filter = func(p *Proc, err error) bool {
+				return filter(p, err) && !p.InContainer()
+			}
+			pf.message += ", excluding containers" + containerDetection()
+		}
+	}
+	return
+}
+
+// containerDetection returns a note when containers are detected from
+// cgroup paths only.
+func containerDetection() string {
+	if _, err := GetInitNamespaces(); err != nil {
+		return fmt.Sprintf(" (cgroup paths only, %v)", err)
+	}
+	return ""
+}
+
+func getFilterer(scope string) (ProcFilter, error) {
+	switch name := strings.ToLower(strings.TrimSpace(scope)); name {
+	case "", "user":
+		return ProcFilter{
+			scope: "user",
+			filter: func(p *Proc, err error) bool {
+				return err == nil && p.Uid == os.Getuid() && p.InUserSlice()
+			},
+			message: "calling user processes",
+		}, nil
 	case "global":
 		return ProcFilter{
 			scope: "global",
@@ -51,7 +152,7 @@ func GetFilterer(scope string) ProcFilter {
 				return err == nil && p.InUserSlice()
 			},
 			message: "processes inside any user slice",
-		}
+		}, nil
 	case "system":
 		return ProcFilter{
 			scope: "system",
@@ -59,7 +160,23 @@ func GetFilterer(scope string) ProcFilter {
 				return err == nil && p.InSystemSlice()
 			},
 			message: "processes inside system slice",
-		}
+		}, nil
+	case "kernel":
+		return ProcFilter{
+			scope: "kernel",
+			filter: func(p *Proc, err error) bool {
+				return err == nil && p.IsKernelThread()
+			},
+			message: "kernel threads",
+		}, nil
+	case "container":
+		return ProcFilter{
+			scope: "container",
+			filter: func(p *Proc, err error) bool {
+				return err == nil && p.InContainer()
+			},
+			message: "processes inside containers" + containerDetection(),
+		}, nil
 	case "all":
 		return ProcFilter{
 			scope: "all",
@@ -67,16 +184,107 @@ func GetFilterer(scope string) ProcFilter {
 				return err == nil
 			},
 			message: "all processes",
+		}, nil
+	default:
+		return ProcFilter{}, fmt.Errorf("unknown scope %q", name)
+	}
+}
+
+// isDescendant reports whether p descends from ancestor, reading the stat
+// of its ancestors.
+func isDescendant(p *Proc, ancestor int) bool {
+	ppid := p.Ppid
+	for depth := 0; ppid > 0 && depth < 4096; depth++ {
+		if ppid == ancestor {
+			return true
+		}
+		var stat ProcStat
+		if err := stat.Read(ppid); err != nil {
+			return false
 		}
+		ppid = stat.Ppid
+	}
+	return false
+}
+
+// getTtyNr returns the device number of /dev/name, as found in the TtyNr
+// field.
+func getTtyNr(name string) (int, error) {
+	stat, err := GetStat(filepath.Join("/dev", filepath.Clean("/"+name)))
+	if err != nil {
+		return -1, err
 	}
-	// Default is user
-	return ProcFilter{
-		scope: "user",
-		filter: func(p *Proc, err error) bool {
-			return err == nil && p.Uid == os.Getuid() && p.InUserSlice()
-		},
-		message: "calling user processes",
+	// See tty_nr in proc(5)
+	major, minor := unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev))
+	return int(minor&0xff | major<<8 | (minor&^0xff)<<12), nil
+}
+
+func getArgFilterer(name, arg string) (pf ProcFilter, err error) {
+	if len(arg) == 0 {
+		return pf, fmt.Errorf("missing argument for scope %q", name)
+	}
+	pf.scope = name
+	switch name {
+	case "session":
+		pf.filter = func(p *Proc, err error) bool {
+			return err == nil && p.SystemdUnit().Session == arg
+		}
+		pf.message = fmt.Sprintf("processes inside session %s", arg)
+	case "unit":
+		if !isUnit(arg) {
+			arg += ".service"
+		}
+		pf.filter = func(p *Proc, err error) bool {
+			return err == nil && p.SystemdUnit().Unit == arg
+		}
+		pf.message = fmt.Sprintf("processes owned by unit %s", arg)
+	case "slice":
+		if !strings.HasSuffix(arg, ".slice") {
+			arg += ".slice"
+		}
+		pf.filter = func(p *Proc, err error) bool {
+			if err != nil {
+				return false
+			}
+			for _, slice := range p.SystemdUnit().Slices {
+				if slice == arg {
+					return true
+				}
+			}
+			return false
+		}
+		pf.message = fmt.Sprintf("processes inside slice %s", arg)
+	case "uid":
+		var uid int
+		if uid, err = strconv.Atoi(arg); err != nil {
+			return pf, fmt.Errorf("invalid uid %q: %w", arg, err)
+		}
+		pf.filter = func(p *Proc, err error) bool {
+			return err == nil && p.Uid == uid
+		}
+		pf.message = fmt.Sprintf("processes owned by uid %d", uid)
+	case "tty":
+		var ttynr int
+		if ttynr, err = getTtyNr(arg); err != nil {
+			return pf, fmt.Errorf("invalid tty %q: %w", arg, err)
+		}
+		pf.filter = func(p *Proc, err error) bool {
+			return err == nil && p.TtyNr == ttynr
+		}
+		pf.message = fmt.Sprintf("processes attached to %s", arg)
+	case "descendants":
+		var pid int
+		if pid, err = strconv.Atoi(arg); err != nil || pid < 1 {
+			return pf, fmt.Errorf("invalid pid %q", arg)
+		}
+		pf.filter = func(p *Proc, err error) bool {
+			return err == nil && isDescendant(p, pid)
+		}
+		pf.message = fmt.Sprintf("descendants of process %d", pid)
+	default:
+		return pf, fmt.Errorf("unknown scope %q", name)
 	}
+	return
 }
 
 // vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,58 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import "testing"
+
+func TestParseFilterer(t *testing.T) {
+	for _, tt := range []struct {
+		scope string
+		want  string
+		fails bool
+	}{
+		{"", "user", false},
+		{"user", "user", false},
+		{"System", "system", false},
+		{"kernel", "kernel", false},
+		{"all", "all", false},
+		{"sytem", "", true},
+		{"uid:0", "uid", false},
+		{"uid:x", "", true},
+		{"foo:1", "", true},
+	} {
+		pf, err := ParseFilterer(tt.scope)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("ParseFilterer(%q) returned scope %q", tt.scope, pf.scope)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseFilterer(%q): %v", tt.scope, err)
+		} else if pf.scope != tt.want {
+			t.Errorf("ParseFilterer(%q) returned scope %q, want %q", tt.scope, pf.scope, tt.want)
+		}
+	}
+}
+
+func TestGetFiltererFallback(t *testing.T) {
+	if pf := GetFilterer("sytem"); pf.scope != "user" {
+		t.Errorf("GetFilterer(%q) returned scope %q, want user", "sytem", pf.scope)
+	}
+}
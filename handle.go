@@ -0,0 +1,237 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrProcessGone is returned when the process referred to by a ProcHandle
+// exited, possibly leaving its pid to another process.
+var ErrProcessGone = errors.New("process gone")
+
+// waitInterval is the delay between two checks while waiting for a process
+// without pidfd.
+const waitInterval = 100 * time.Millisecond
+
+// ProcHandle refers to a process, identified by its pid and start time, so
+// that a recycled pid is never mistaken for the original process. It relies
+// on a pidfd when the kernel supports pidfd_open (5.3 and later).
+type ProcHandle struct {
+	Pid       int
+	StartTime uint64
+	fd        int
+}
+
+// OpenProc returns a ProcHandle for the process currently running as pid.
+func OpenProc(pid int) (*ProcHandle, error) {
+	return openProc(pid, nil)
+}
+
+// Handle returns a ProcHandle for p, failing with ErrProcessGone when p
+// exited since it was read.
+func (p *Proc) Handle() (*ProcHandle, error) {
+	return openProc(p.Pid, &p.StartTime)
+}
+
+func openProc(pid int, starttime *uint64) (h *ProcHandle, err error) {
+	h = &ProcHandle{Pid: pid, fd: -1}
+	if fd, err := unix.PidfdOpen(pid, 0); err == nil {
+		h.fd = fd
+	} else if err == unix.ESRCH {
+		return nil, ErrProcessGone
+	}
+	// read start time after opening the pidfd, so that both refer to the
+	// same process
+	var stat ProcStat
+	if err = stat.Read(pid); err != nil {
+		h.Close()
+		return nil, ErrProcessGone
+	}
+	if starttime != nil && stat.StartTime != *starttime {
+		h.Close()
+		return nil, ErrProcessGone
+	}
+	h.StartTime = stat.StartTime
+	return
+}
+
+// HasPidfd reports whether h holds a pidfd.
+func (h *ProcHandle) HasPidfd() bool {
+	return h.fd >= 0
+}
+
+// Verify returns ErrProcessGone when the process exited.
+func (h *ProcHandle) Verify() error {
+	if h.fd >= 0 {
+		// a pidfd never refers to another process; signal 0 fails with
+		// ESRCH once the process exited
+		if err := unix.PidfdSendSignal(h.fd, 0, nil, 0); err == unix.ESRCH {
+			return ErrProcessGone
+		} else if err != nil && err != unix.EPERM {
+			return err
+		}
+	}
+	var stat ProcStat
+	if err := stat.Read(h.Pid); err != nil || stat.StartTime != h.StartTime {
+		return ErrProcessGone
+	}
+	if stat.State == "Z" || stat.State == "X" {
+		return ErrProcessGone
+	}
+	return nil
+}
+
+// Signal sends sig to the process.
+func (h *ProcHandle) Signal(sig unix.Signal) error {
+	if h.fd >= 0 {
+		err := unix.PidfdSendSignal(h.fd, sig, nil, 0)
+		if err == unix.ESRCH {
+			return ErrProcessGone
+		}
+		return err
+	}
+	if err := h.Verify(); err != nil {
+		return err
+	}
+	return unix.Kill(h.Pid, sig)
+}
+
+// Wait blocks until the process exits or ctx is done.
+func (h *ProcHandle) Wait(ctx context.Context) error {
+	for {
+		if h.fd >= 0 {
+			// a pidfd becomes readable when the process exits
+			fds := []unix.PollFd{{Fd: int32(h.fd), Events: unix.POLLIN}}
+			n, err := unix.Poll(fds, int(waitInterval/time.Millisecond))
+			if err != nil && err != unix.EINTR {
+				return err
+			}
+			if n > 0 {
+				return nil
+			}
+		} else {
+			if err := h.Verify(); err == ErrProcessGone {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if h.fd < 0 {
+			time.Sleep(waitInterval)
+		}
+	}
+}
+
+// SetNice sets the nice value of every thread of the process.
+func (h *ProcHandle) SetNice(nice int) error {
+	return h.eachThread(func(tid int) error { return SetNice(tid, nice) })
+}
+
+// SetScheduler sets the CPU scheduling policy and real-time priority of
+// every thread of the process.
+func (h *ProcHandle) SetScheduler(class, priority int) error {
+	return h.eachThread(func(tid int) error {
+		return Sched_SetScheduler(tid, class, priority)
+	})
+}
+
+// SetIOPrio sets the I/O scheduling class and priority of every thread of
+// the process.
+func (h *ProcHandle) SetIOPrio(class, data int) error {
+	ioprio := IOPrio_Join(class, data)
+	return h.eachThread(func(tid int) error { return IOPrio_Set(tid, ioprio) })
+}
+
+// SetOomScoreAdj sets the OOM killer score adjustment of the process.
+func (h *ProcHandle) SetOomScoreAdj(score int) error {
+	if err := h.Verify(); err != nil {
+		return err
+	}
+	return SetOomScoreAdj(h.Pid, score)
+}
+
+// SetAffinity sets the CPUs every thread of the process may run on.
+func (h *ProcHandle) SetAffinity(cpus []int) error {
+	return h.eachThread(func(tid int) error { return Sched_SetAffinity(tid, cpus) })
+}
+
+// SetAutogroupNice sets the nice value of the autogroup of the process.
+func (h *ProcHandle) SetAutogroupNice(nice int) error {
+	if err := h.Verify(); err != nil {
+		return err
+	}
+	return SetAutogroupNice(h.Pid, nice)
+}
+
+// SetTimerSlack sets the timer slack of the process, in nanoseconds.
+func (h *ProcHandle) SetTimerSlack(ns int) error {
+	if err := h.Verify(); err != nil {
+		return err
+	}
+	return SetTimerSlack(h.Pid, ns)
+}
+
+// eachThread calls set with the id of every thread of the process, since
+// setpriority, sched_setscheduler, sched_setaffinity and ioprio_set only
+// change the thread given. Threads exiting meanwhile are ignored.
+func (h *ProcHandle) eachThread(set func(tid int) error) error {
+	if err := h.Verify(); err != nil {
+		return err
+	}
+	tids, err := ListTids(h.Pid)
+	if err != nil {
+		return ErrProcessGone
+	}
+	for _, tid := range tids {
+		if err := set(tid); err == unix.ESRCH && tid != h.Pid {
+			continue
+		} else if err != nil && tid != h.Pid {
+			return fmt.Errorf("thread %d: %w", tid, err)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the pidfd, if any.
+func (h *ProcHandle) Close() (err error) {
+	if h.fd >= 0 {
+		err = unix.Close(h.fd)
+		h.fd = -1
+	}
+	return
+}
+
+func (h *ProcHandle) String() string {
+	return fmt.Sprintf("{Pid: %d, StartTime: %d, Pidfd: %v}", h.Pid, h.StartTime, h.HasPidfd())
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,52 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHandleSetIOPrio(t *testing.T) {
+	// a thread with its own I/O class
+	tid := lockedThread(t)
+	if err := IOPrio_Set(tid, IOPrio_Join(IOPRIO_CLASS_IDLE, 0)); err != nil {
+		t.Fatal(err)
+	}
+	h, err := OpenProc(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if err := h.SetIOPrio(IOPRIO_CLASS_BE, 4); err != nil {
+		t.Fatal(err)
+	}
+	self := readSelf(t)
+	if err := self.setIOPrio(); err != nil {
+		t.Fatal(err)
+	}
+	tasks := getTaskValues(self, true, false)
+	tasks = append(tasks, taskValues{Tid: self.Pid, IOPrioClass: self.IOPrioClass, IOPrioData: self.IOPrioData})
+	for _, task := range tasks {
+		if task.IOPrioClass != IOPRIO_CLASS_BE || task.IOPrioData != 4 {
+			t.Errorf("thread %d has I/O priority %s:%d, want best-effort:4",
+				task.Tid, IO.Class[task.IOPrioClass], task.IOPrioData)
+		}
+	}
+}
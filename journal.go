@@ -0,0 +1,194 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// PrintDiff writes actions to w as a diff of the current and target values,
+// grouped by process.
+func PrintDiff(w io.Writer, actions []Action) (err error) {
+	var last procKey
+	for i, a := range actions {
+		if key := (procKey{a.Pid, a.StartTime}); i == 0 || key != last {
+			header := fmt.Sprintf("%d (%s)", a.Pid, a.Comm)
+			if len(a.Rule) > 0 {
+				header += fmt.Sprintf(" [%s]", a.Rule)
+			}
+			if _, err = fmt.Fprintln(w, header); err != nil {
+				return
+			}
+			last = key
+		}
+		if _, err = fmt.Fprintf(w, "- %s: %v\n+ %s: %v\n", a.Field, a.Old, a.Field, a.New); err != nil {
+			return
+		}
+		if a.Err != nil {
+			if _, err = fmt.Fprintf(w, "! %v\n", a.Err); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// JournalEntry holds the original values of a process, before any change.
+type JournalEntry struct {
+	Pid       int      `json:"pid"`
+	Comm      string   `json:"comm"`
+	StartTime uint64   `json:"starttime"`
+	Undo      Settings `json:"undo"`
+}
+
+// undoSettings returns the values of p for the fields changed by actions.
+func undoSettings(p *Proc, actions []Action) (s Settings) {
+	for _, a := range actions {
+		if !a.Applied {
+			continue
+		}
+		switch a.Field {
+		case "sched":
+			s.SchedPolicy = IntPtr(p.Policy)
+			if inSlice(p.Policy, CPU.NeedPriority) {
+				s.RTPrio = IntPtr(p.RTPrio)
+			}
+		case "nice":
+			s.Nice = IntPtr(p.Nice)
+		case "ioprio":
+			s.IOClass = IntPtr(p.IOPrioClass)
+			if inSlice(p.IOPrioClass, IO.NeedPriority) {
+				s.IONice = IntPtr(p.IOPrioData)
+			}
+		case "oom_score_adj":
+			s.OomScoreAdj = IntPtr(p.OomScoreAdj)
+		case "affinity":
+			s.Affinity, _ = ParseCPUList(fmt.Sprint(a.Old))
+		case "autogroup_nice":
+			s.AutogroupNice = IntPtr(p.AutogroupNice)
+		case "timerslack_ns":
+			s.TimerSlack = IntPtr(p.TimerSlack)
+		}
+	}
+	return
+}
+
+// Journal records the original values of the processes changed, so that
+// they can be restored.
+type Journal struct {
+	Entries []*JournalEntry `json:"entries"`
+	index   map[procKey]*JournalEntry
+	mutex   sync.Mutex
+}
+
+// NewJournal returns an empty Journal.
+func NewJournal() *Journal {
+	return &Journal{index: make(map[procKey]*JournalEntry)}
+}
+
+// LoadJournal reads the Journal saved in path.
+func LoadJournal(path string) (*Journal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	j := NewJournal()
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, entry := range j.Entries {
+		j.index[procKey{entry.Pid, entry.StartTime}] = entry
+	}
+	return j, nil
+}
+
+// Record saves the values of p changed by the applied actions. Values
+// already recorded for p are kept, so that the journal always holds the
+// values found before the first change.
+func (j *Journal) Record(p *Proc, actions []Action) {
+	undo := undoSettings(p, actions)
+	if undo.IsEmpty() {
+		return
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	key := procKey{p.Pid, p.StartTime}
+	if entry, found := j.index[key]; found {
+		entry.Undo = undo.Merge(entry.Undo)
+		return
+	}
+	entry := &JournalEntry{p.Pid, p.Comm, p.StartTime, undo}
+	j.Entries = append(j.Entries, entry)
+	j.index[key] = entry
+}
+
+// Save writes the journal to path, replacing it atomically.
+func (j *Journal) Save(path string) error {
+	j.mutex.Lock()
+	data, err := json.MarshalIndent(j, "", "  ")
+	j.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Restore applies the recorded values to the processes still running with
+// the same start time, and returns the actions taken with the entries of the
+// processes gone.
+func (j *Journal) Restore() (actions []Action, gone []*JournalEntry) {
+	j.mutex.Lock()
+	entries := append([]*JournalEntry{}, j.Entries...)
+	j.mutex.Unlock()
+	for _, entry := range entries {
+		stat, err := ReadStat(entry.Pid)
+		if err != nil {
+			gone = append(gone, entry)
+			continue
+		}
+		p, err := NewProcFromStat(stat, LoadAll|entry.Undo.loadMask())
+		if err != nil || p.StartTime != entry.StartTime {
+			gone = append(gone, entry)
+			continue
+		}
+		actions = append(actions, entry.Undo.Apply(p)...)
+	}
+	return
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,103 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func applied(fields ...string) (actions []Action) {
+	for _, field := range fields {
+		actions = append(actions, Action{FieldChange: FieldChange{Field: field}, Applied: true})
+	}
+	return
+}
+
+func TestJournalRecord(t *testing.T) {
+	j := NewJournal()
+	p := snapshotProc(10, 100, 0, 0, 0)
+	p.Nice, p.OomScoreAdj = 0, 0
+	j.Record(p, applied("nice"))
+	// not applied, not recorded
+	j.Record(p, []Action{{FieldChange: FieldChange{Field: "oom_score_adj"}}})
+	changed := *p
+	changed.Nice, changed.OomScoreAdj = 5, 200
+	j.Record(&changed, applied("nice", "oom_score_adj"))
+	if len(j.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(j.Entries))
+	}
+	want := Settings{Nice: IntPtr(0), OomScoreAdj: IntPtr(200)}
+	if undo := j.Entries[0].Undo; !reflect.DeepEqual(undo, want) {
+		t.Errorf("got undo %v, want %v", undo, want)
+	}
+}
+
+func TestJournalSave(t *testing.T) {
+	j := NewJournal()
+	for _, pid := range []int{10, 11} {
+		p := snapshotProc(pid, uint64(pid*100), 0, 0, 0)
+		p.Comm, p.Nice = "test", pid
+		j.Record(p, applied("nice"))
+	}
+	path := filepath.Join(t.TempDir(), "journal.json")
+	if err := j.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Entries, j.Entries) {
+		t.Errorf("got entries %v, want %v", loaded.Entries, j.Entries)
+	}
+	if !reflect.DeepEqual(loaded.index, j.index) {
+		t.Errorf("got index %v, want %v", loaded.index, j.index)
+	}
+	// the original value survives a restart
+	p := snapshotProc(10, 1000, 0, 0, 0)
+	p.Nice = 19
+	loaded.Record(p, applied("nice"))
+	if len(loaded.Entries) != 2 || *loaded.Entries[0].Undo.Nice != 10 {
+		t.Errorf("got entries %v after Record", loaded.Entries)
+	}
+	if _, err := LoadJournal(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadJournal succeeded on a missing file")
+	}
+}
+
+func TestJournalRestore(t *testing.T) {
+	self := readSelf(t)
+	j := NewJournal()
+	// the current value, nothing to restore
+	j.Record(self, applied("nice"))
+	// the same pid, started at another time
+	other := *self
+	other.StartTime++
+	j.Record(&other, applied("nice"))
+	actions, gone := j.Restore()
+	if len(actions) > 0 {
+		t.Errorf("got actions %v", actions)
+	}
+	if len(gone) != 1 || gone[0].Pid != os.Getpid() || gone[0].StartTime != other.StartTime {
+		t.Errorf("got gone %v, want the entry started at %d", gone, other.StartTime)
+	}
+}
@@ -0,0 +1,99 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets in struct linux_dirent64
+const (
+	direntReclen = 16
+	direntType   = 18
+	direntName   = 19
+)
+
+// parsePid returns the pid for a directory name, or -1 when name is not a
+// number.
+func parsePid(name []byte) (pid int) {
+	if len(name) == 0 {
+		return -1
+	}
+	for _, c := range name {
+		if c < '0' || c > '9' {
+			return -1
+		}
+		pid = pid*10 + int(c-'0')
+	}
+	return
+}
+
+// ListPids returns the pids of the processes found in /proc, reading the
+// directory entries with getdents64.
+func ListPids() ([]int, error) {
+	return listPidDir("/proc")
+}
+
+// ListTids returns the ids of the threads of pid, found in
+// /proc/[pid]/task. The id of the main thread is pid.
+func ListTids(pid int) ([]int, error) {
+	return listPidDir(fmt.Sprintf("/proc/%d/task", pid))
+}
+
+// listPidDir returns the numeric subdirectories of path.
+func listPidDir(path string) (pids []int, err error) {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+	buffer := make([]byte, 32768)
+	for {
+		var n int
+		if n, err = unix.Getdents(fd, buffer); err != nil || n <= 0 {
+			return
+		}
+		for offset := 0; offset < n; {
+			record := buffer[offset:]
+			reclen := int(*(*uint16)(unsafe.Pointer(&record[direntReclen])))
+			if reclen == 0 {
+				break
+			}
+			offset += reclen
+			if record[direntType] != unix.DT_DIR {
+				continue
+			}
+			name := record[direntName:reclen]
+			for i, c := range name {
+				if c == 0 {
+					name = name[:i]
+					break
+				}
+			}
+			if pid := parsePid(name); pid > 0 {
+				pids = append(pids, pid)
+			}
+		}
+	}
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
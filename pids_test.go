@@ -0,0 +1,40 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"os"
+	"testing"
+)
+
+func TestListPids(t *testing.T) {
+	pids, err := ListPids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[int]bool, len(pids))
+	for _, pid := range pids {
+		found[pid] = true
+	}
+	for _, pid := range []int{1, os.Getpid()} {
+		if !found[pid] {
+			t.Errorf("pid %d not listed", pid)
+		}
+	}
+}
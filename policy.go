@@ -19,6 +19,8 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package goprocfs
 
 import (
+	"strconv"
+	"strings"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -33,6 +35,22 @@ type SchedulingPolicy struct {
 	None            int
 }
 
+// Lookup returns the class named name, ignoring case and any "SCHED_"
+// prefix, or given as a number.
+func (sp SchedulingPolicy) Lookup(name string) (class int, found bool) {
+	if class, err := strconv.Atoi(name); err == nil {
+		_, found = sp.Class[class]
+		return class, found
+	}
+	name = strings.TrimPrefix(strings.ToLower(name), "sched_")
+	for class, value := range sp.Class {
+		if strings.TrimPrefix(strings.ToLower(value), "sched_") == name {
+			return class, true
+		}
+	}
+	return -1, false
+}
+
 var IO SchedulingPolicy = SchedulingPolicy{
 	Class: map[int]string{
 		0: "none",
@@ -70,9 +88,12 @@ const (
 	IDLE
 )
 
-func IOPrio_Get(pid int) (int, error) {
+// IOPrio_GetWho returns the I/O priority of the process, process group or
+// user who, according to which. For a group or a user, it is the highest
+// priority among their processes.
+func IOPrio_GetWho(which, who int) (int, error) {
 	ioprio, _, err := unix.Syscall(
-		unix.SYS_IOPRIO_GET, IOPRIO_WHO_PROCESS, uintptr(pid), 0,
+		unix.SYS_IOPRIO_GET, uintptr(which), uintptr(who), 0,
 	)
 	if err == 0 {
 		return int(ioprio), nil
@@ -80,6 +101,54 @@ func IOPrio_Get(pid int) (int, error) {
 	return -1, err
 }
 
+// IOPrio_SetWho sets the I/O priority of the process, process group or user
+// who, according to which, in a single call.
+func IOPrio_SetWho(which, who, ioprio int) error {
+	_, _, err := unix.Syscall(
+		unix.SYS_IOPRIO_SET, uintptr(which), uintptr(who), uintptr(ioprio),
+	)
+	if err == 0 {
+		return nil
+	}
+	return err
+}
+
+func IOPrio_Get(pid int) (int, error) {
+	return IOPrio_GetWho(IOPRIO_WHO_PROCESS, pid)
+}
+
+func IOPrio_Set(pid, ioprio int) error {
+	return IOPrio_SetWho(IOPRIO_WHO_PROCESS, pid, ioprio)
+}
+
+// IOPrio_GetPgrp returns the highest I/O priority in the process group pgid,
+// 0 meaning the group of the caller.
+func IOPrio_GetPgrp(pgid int) (int, error) {
+	return IOPrio_GetWho(IOPRIO_WHO_PGRP, pgid)
+}
+
+// IOPrio_SetPgrp sets the I/O priority of all the processes in the group
+// pgid, 0 meaning the group of the caller.
+func IOPrio_SetPgrp(pgid, ioprio int) error {
+	return IOPrio_SetWho(IOPRIO_WHO_PGRP, pgid, ioprio)
+}
+
+// IOPrio_GetUser returns the highest I/O priority among the processes of
+// uid, 0 meaning the real user of the caller.
+func IOPrio_GetUser(uid int) (int, error) {
+	return IOPrio_GetWho(IOPRIO_WHO_USER, uid)
+}
+
+// IOPrio_SetUser sets the I/O priority of all the processes of uid, 0
+// meaning the real user of the caller.
+func IOPrio_SetUser(uid, ioprio int) error {
+	return IOPrio_SetWho(IOPRIO_WHO_USER, uid, ioprio)
+}
+
+func IOPrio_Join(class, data int) int {
+	return class<<IOPRIO_CLASS_SHIFT | data&0xff
+}
+
 func IOPrio_Split(ioprio int, class, data *int) {
 	// From https://www.kernel.org/doc/html/latest/block/ioprio.html
 	*class = ioprio >> IOPRIO_CLASS_SHIFT
@@ -113,8 +182,10 @@ var CPU SchedulingPolicy = SchedulingPolicy{
 	None:            0,
 }
 
+// Sched_Param mirrors the C struct sched_param, whose int is 32 bits wide
+// on every Linux target.
 type Sched_Param struct {
-	Sched_Priority int
+	Sched_Priority int32
 }
 
 func Sched_GetScheduler(pid int) (int, error) {
@@ -131,9 +202,87 @@ func Sched_GetParam(pid int) (int, error) {
 		unix.SYS_SCHED_GETPARAM, uintptr(pid), uintptr(unsafe.Pointer(&param)), 0,
 	)
 	if err == 0 {
-		return param.Sched_Priority, nil
+		return int(param.Sched_Priority), nil
 	}
 	return -1, err
 }
 
+func Sched_SetScheduler(pid, class, priority int) error {
+	param := Sched_Param{Sched_Priority: int32(priority)}
+	_, _, err := unix.Syscall(
+		unix.SYS_SCHED_SETSCHEDULER,
+		uintptr(pid),
+		uintptr(class),
+		uintptr(unsafe.Pointer(&param)),
+	)
+	if err == 0 {
+		return nil
+	}
+	return err
+}
+
+func Sched_GetAffinity(pid int) (cpus []int, err error) {
+	var set unix.CPUSet
+	if err = unix.SchedGetaffinity(pid, &set); err != nil {
+		return
+	}
+	for cpu := 0; cpu < len(set)*64; cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return
+}
+
+func Sched_SetAffinity(pid int, cpus []int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	return unix.SchedSetaffinity(pid, &set)
+}
+
+func SetNice(pid, nice int) error {
+	return unix.Setpriority(unix.PRIO_PROCESS, pid, nice)
+}
+
+// getNice returns the lowest nice value of the process, process group or
+// user who, according to which.
+func getNice(which, who int) (int, error) {
+	// the raw syscall returns 20 - nice, so that it is never negative
+	prio, err := unix.Getpriority(which, who)
+	if err != nil {
+		return 0, err
+	}
+	return 20 - prio, nil
+}
+
+func GetNice(pid int) (int, error) {
+	return getNice(unix.PRIO_PROCESS, pid)
+}
+
+// GetPgrpNice returns the lowest nice value in the process group pgid, 0
+// meaning the group of the caller.
+func GetPgrpNice(pgid int) (int, error) {
+	return getNice(unix.PRIO_PGRP, pgid)
+}
+
+// SetPgrpNice sets the nice value of all the processes in the group pgid, 0
+// meaning the group of the caller.
+func SetPgrpNice(pgid, nice int) error {
+	return unix.Setpriority(unix.PRIO_PGRP, pgid, nice)
+}
+
+// GetUserNice returns the lowest nice value among the processes of uid, 0
+// meaning the real user of the caller.
+func GetUserNice(uid int) (int, error) {
+	return getNice(unix.PRIO_USER, uid)
+}
+
+// SetUserNice sets the nice value of all the processes of uid, 0 meaning the
+// real user of the caller.
+func SetUserNice(uid, nice int) error {
+	return unix.Setpriority(unix.PRIO_USER, uid, nice)
+}
+
 // vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,47 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestSchedParam(t *testing.T) {
+	// struct sched_param holds a single C int
+	if size := unsafe.Sizeof(Sched_Param{}); size != 4 {
+		t.Errorf("Sched_Param is %d bytes, want 4", size)
+	}
+	policy, err := Sched_GetScheduler(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	priority, err := Sched_GetParam(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inSlice(policy, CPU.NeedPriority) != (priority > 0) {
+		t.Errorf("got priority %d for policy %s", priority, CPUSched[policy])
+	}
+	// setting the current values back is always allowed
+	if err := Sched_SetScheduler(os.Getpid(), policy, priority); err != nil {
+		t.Error(err)
+	}
+}
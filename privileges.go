@@ -0,0 +1,262 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Denial reports a part of Settings that would be refused, and why.
+type Denial struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (d Denial) String() string {
+	return fmt.Sprintf("%s: %s", d.Field, d.Reason)
+}
+
+// Privileges holds the credentials of the current process that the kernel
+// checks before scheduling changes.
+type Privileges struct {
+	Euid int `json:"euid"`
+	// Effective capabilities, as a bit set.
+	Effective uint64 `json:"effective"`
+	// Limits of the current process, checked for the autogroup nice value
+	// only; other changes depend on the limits of the target.
+	RlimitNice   uint64 `json:"rlimit_nice"`
+	RlimitRTPrio uint64 `json:"rlimit_rtprio"`
+}
+
+// GetPrivileges returns the credentials of the current process.
+func GetPrivileges() (pv Privileges, err error) {
+	pv.Euid = unix.Geteuid()
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err = unix.Capget(&header, &data[0]); err != nil {
+		return
+	}
+	pv.Effective = uint64(data[1].Effective)<<32 | uint64(data[0].Effective)
+	var rlim unix.Rlimit
+	if err = unix.Getrlimit(unix.RLIMIT_NICE, &rlim); err != nil {
+		return
+	}
+	pv.RlimitNice = rlim.Cur
+	if err = unix.Getrlimit(unix.RLIMIT_RTPRIO, &rlim); err != nil {
+		return
+	}
+	pv.RlimitRTPrio = rlim.Cur
+	return
+}
+
+// HasCap reports whether capability is effective.
+func (pv Privileges) HasCap(capability int) bool {
+	return pv.Effective&(1<<uint(capability)) != 0
+}
+
+// canNice reports whether nice is allowed by rlimit, the RLIMIT_NICE of
+// the target, or CAP_SYS_NICE, as the kernel does.
+func (pv Privileges) canNice(nice int, rlimit uint64) bool {
+	return uint64(20-nice) <= rlimit || pv.HasCap(unix.CAP_SYS_NICE)
+}
+
+// target holds the credentials and limits of the target process that the
+// kernel checks before scheduling changes.
+type target struct {
+	Ruid, Euid   int
+	RlimitNice   uint64
+	RlimitRTPrio uint64
+}
+
+// GetStatusUids returns the real and effective user ids of pid, read from
+// /proc/[pid]/status.
+func GetStatusUids(pid int) (ruid, euid int, err error) {
+	data, err := GetResource(pid, "status")
+	if err != nil {
+		return -1, -1, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] != "Uid:" {
+			continue
+		}
+		if ruid, err = strconv.Atoi(fields[1]); err == nil {
+			euid, err = strconv.Atoi(fields[2])
+		}
+		return
+	}
+	return -1, -1, fmt.Errorf("no uid found for pid %d", pid)
+}
+
+// GetSchedLimits returns the soft RLIMIT_NICE and RLIMIT_RTPRIO of pid, read
+// from /proc/[pid]/limits, readable by any user unlike prlimit.
+func GetSchedLimits(pid int) (nice, rtprio uint64, err error) {
+	data, err := GetResource(pid, "limits")
+	if err != nil {
+		return
+	}
+	var found int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		var limit *uint64
+		switch {
+		case strings.HasPrefix(line, "Max nice priority "):
+			limit = &nice
+		case strings.HasPrefix(line, "Max realtime priority "):
+			limit = &rtprio
+		default:
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return 0, 0, fmt.Errorf("invalid limit %q for pid %d", line, pid)
+		}
+		// Max, nice or realtime, priority, soft and hard limits
+		if fields[3] == "unlimited" {
+			*limit = unix.RLIM_INFINITY
+		} else if *limit, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid limit %q for pid %d", line, pid)
+		}
+		found++
+	}
+	if found != 2 {
+		return 0, 0, fmt.Errorf("no scheduling limits found for pid %d", pid)
+	}
+	return
+}
+
+// CanApply returns the parts of s that the current process would not be
+// allowed to apply to pid, with the reason. Unchanged values are never
+// denied.
+func CanApply(pid int, s Settings) (denials []Denial, err error) {
+	pv, err := GetPrivileges()
+	if err != nil {
+		return
+	}
+	stat, err := ReadStat(pid)
+	if err != nil {
+		return nil, ErrProcessGone
+	}
+	p, err := NewProcFromStat(stat, LoadOomScoreAdj|LoadIOPrio|LoadAutogroup|LoadTimerSlack)
+	if err != nil {
+		return
+	}
+	var t target
+	if t.Ruid, t.Euid, err = GetStatusUids(pid); err != nil {
+		return
+	}
+	if t.RlimitNice, t.RlimitRTPrio, err = GetSchedLimits(pid); err != nil {
+		return
+	}
+	return pv.check(p, t, s), nil
+}
+
+// check returns the denials for the changes from p to s. The limits checked
+// are those of the target t, but for the autogroup nice value, checked
+// against the limit of the caller.
+func (pv Privileges) check(p *Proc, t target, s Settings) (denials []Denial) {
+	deny := func(field, format string, a ...interface{}) {
+		denials = append(denials, Denial{field, fmt.Sprintf(format, a...)})
+	}
+	niceCap := pv.HasCap(unix.CAP_SYS_NICE)
+	// setpriority, sched_setscheduler, sched_setaffinity and ioprio_set
+	// require the same owner, or CAP_SYS_NICE
+	owner := pv.Euid == t.Ruid || pv.Euid == t.Euid
+	const notOwner = "target owned by uid %d and CAP_SYS_NICE missing"
+	for _, c := range s.changes(p) {
+		switch c.Field {
+		case "sched":
+			if !owner && !niceCap {
+				deny(c.Field, notOwner, t.Euid)
+				continue
+			}
+			if niceCap {
+				continue
+			}
+			policy, rtprio, _ := s.schedTarget(p)
+			switch {
+			case policy == SCHED_DEADLINE:
+				deny(c.Field, "%s requires CAP_SYS_NICE", CPUSched[policy])
+			case inSlice(policy, CPU.NeedCredentials):
+				if policy != p.Policy && t.RlimitRTPrio == 0 {
+					deny(c.Field, "RLIMIT_RTPRIO is 0 and CAP_SYS_NICE missing")
+				} else if rtprio > p.RTPrio && uint64(rtprio) > t.RlimitRTPrio {
+					deny(c.Field, "rtprio %d above RLIMIT_RTPRIO %d and CAP_SYS_NICE missing",
+						rtprio, t.RlimitRTPrio)
+				}
+			case p.Policy == SCHED_IDLE && policy != SCHED_IDLE && !pv.canNice(p.Nice, t.RlimitNice):
+				deny(c.Field, "leaving %s not allowed by RLIMIT_NICE %d and CAP_SYS_NICE missing",
+					CPUSched[p.Policy], t.RlimitNice)
+			}
+		case "nice":
+			if !owner && !niceCap {
+				deny(c.Field, notOwner, t.Euid)
+			} else if nice := *s.Nice; nice < p.Nice && !pv.canNice(nice, t.RlimitNice) {
+				deny(c.Field, "nice %d below RLIMIT_NICE floor %d and CAP_SYS_NICE missing",
+					nice, 20-int(t.RlimitNice))
+			}
+		case "ioprio":
+			class, _, _ := s.ioTarget(p)
+			if !owner && !niceCap {
+				deny(c.Field, notOwner, t.Euid)
+			} else if class == IOPRIO_CLASS_RT && !niceCap && !pv.HasCap(unix.CAP_SYS_ADMIN) {
+				deny(c.Field, "%s class requires CAP_SYS_NICE or CAP_SYS_ADMIN", IO.Class[class])
+			}
+		case "oom_score_adj":
+			// /proc/[pid]/oom_score_adj belongs to the effective owner
+			// The kernel lets anyone lower the score down to oom_score_adj_min,
+			// the last value set with CAP_SYS_RESOURCE. It is not exposed, so
+			// that the check is conservative: any lowering is denied without
+			// CAP_SYS_RESOURCE.
+			if pv.Euid != t.Euid && !pv.HasCap(unix.CAP_DAC_OVERRIDE) {
+				deny(c.Field, "target owned by uid %d and CAP_DAC_OVERRIDE missing", t.Euid)
+			} else if score := *s.OomScoreAdj; score < p.OomScoreAdj && !pv.HasCap(unix.CAP_SYS_RESOURCE) {
+				deny(c.Field, "lowering %d to %d may require CAP_SYS_RESOURCE", p.OomScoreAdj, score)
+			}
+		case "affinity":
+			if !owner && !niceCap {
+				deny(c.Field, notOwner, t.Euid)
+			}
+		case "autogroup_nice":
+			// the kernel checks the RLIMIT_NICE of the writer
+			if pv.Euid != t.Euid && !pv.HasCap(unix.CAP_DAC_OVERRIDE) {
+				deny(c.Field, "target owned by uid %d and CAP_DAC_OVERRIDE missing", t.Euid)
+			} else if nice := *s.AutogroupNice; nice < 0 && !pv.canNice(nice, pv.RlimitNice) {
+				deny(c.Field, "nice %d below RLIMIT_NICE floor %d and CAP_SYS_NICE missing",
+					nice, 20-int(pv.RlimitNice))
+			}
+		case "timerslack_ns":
+			// only the slack of the current process is free to change
+			if p.Pid != unix.Getpid() && !niceCap {
+				deny(c.Field, "target is not the current process and CAP_SYS_NICE missing")
+			}
+		}
+	}
+	return
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,95 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestGetSchedLimits(t *testing.T) {
+	nice, rtprio, err := GetSchedLimits(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rlim unix.Rlimit
+	for _, limit := range []struct {
+		resource int
+		got      uint64
+	}{
+		{unix.RLIMIT_NICE, nice},
+		{unix.RLIMIT_RTPRIO, rtprio},
+	} {
+		if err := unix.Getrlimit(limit.resource, &rlim); err != nil {
+			t.Fatal(err)
+		}
+		if limit.got != rlim.Cur {
+			t.Errorf("resource %d: got %d, want %d", limit.resource, limit.got, rlim.Cur)
+		}
+	}
+}
+
+func TestCheckTargetLimits(t *testing.T) {
+	stat, err := ReadStat(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProcFromStat(stat, LoadAutogroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Nice, p.Policy, p.RTPrio, p.AutogroupNice = 0, SCHED_OTHER, 0, 0
+	// an unprivileged caller without limits, owning the target
+	pv := Privileges{Euid: 1000}
+	owner := target{Ruid: 1000, Euid: 1000, RlimitNice: 25, RlimitRTPrio: 10}
+	for _, tt := range []struct {
+		name string
+		s    Settings
+		pv   uint64
+		want []string
+	}{
+		{"nice within target limit", Settings{Nice: IntPtr(-5)}, 0, nil},
+		{"nice beyond target limit", Settings{Nice: IntPtr(-6)}, 0, []string{"nice"}},
+		{
+			"rtprio within target limit",
+			Settings{SchedPolicy: IntPtr(SCHED_RR), RTPrio: IntPtr(10)},
+			0, nil,
+		},
+		{
+			"rtprio beyond target limit",
+			Settings{SchedPolicy: IntPtr(SCHED_RR), RTPrio: IntPtr(11)},
+			0, []string{"sched"},
+		},
+		{"autogroup beyond caller limit", Settings{AutogroupNice: IntPtr(-5)}, 0, []string{"autogroup_nice"}},
+		{"autogroup within caller limit", Settings{AutogroupNice: IntPtr(-5)}, 25, nil},
+		{"lowering oom_score_adj", Settings{OomScoreAdj: IntPtr(-1)}, 0, []string{"oom_score_adj"}},
+	} {
+		pv.RlimitNice = tt.pv
+		var got []string
+		for _, d := range pv.check(p, owner, tt.s) {
+			got = append(got, d.Field)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got denials %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
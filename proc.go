@@ -19,17 +19,15 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package goprocfs
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/user"
-	"path/filepath"
 	"runtime"
-	"sort"
 	"strconv"
 	"strings"
-	"sync"
 
 	"golang.org/x/sys/unix"
 )
@@ -57,8 +55,22 @@ func GetUid(pid int) int {
 	return -1
 }
 
+// GetOwner returns the effective user and group ids of pid, or -1.
+func GetOwner(pid int) (uid, gid int) {
+	if stat, err := GetStat(fmt.Sprintf("/proc/%d", pid)); err == nil {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return -1, -1
+}
+
+// GetUser resolves uid with the UserResolver set by SetUserResolver.
 func GetUser(uid int) (*user.User, error) {
-	return user.LookupId(strconv.Itoa(uid))
+	return getUserResolver().LookupUser(uid)
+}
+
+// GetGroup resolves gid with the UserResolver set by SetUserResolver.
+func GetGroup(gid int) (*user.Group, error) {
+	return getUserResolver().LookupGroup(gid)
 }
 
 func GetCgroup(pid int) (cgroup string, err error) {
@@ -75,21 +87,133 @@ func GetOomScoreAdj(pid int) (score int, err error) {
 	return
 }
 
+func SetOomScoreAdj(pid, score int) error {
+	return ioutil.WriteFile(
+		fmt.Sprintf("/proc/%d/oom_score_adj", pid), []byte(strconv.Itoa(score)), 0644,
+	)
+}
+
+// GetAutogroup returns the autogroup id and nice value of pid, read from
+// /proc/[pid]/autogroup, e.g. "/autogroup-42 nice 0".
+func GetAutogroup(pid int) (id, nice int, err error) {
+	data, err := GetResource(pid, "autogroup")
+	if err != nil {
+		return -1, 0, err
+	}
+	line := strings.TrimSpace(string(data))
+	if _, err = fmt.Sscanf(line, "/autogroup-%d nice %d", &id, &nice); err != nil {
+		return -1, 0, fmt.Errorf("invalid autogroup %q", line)
+	}
+	return
+}
+
+// SetAutogroupNice sets the nice value of the autogroup of pid, shared by
+// all the processes of its session.
+func SetAutogroupNice(pid, nice int) error {
+	return ioutil.WriteFile(
+		fmt.Sprintf("/proc/%d/autogroup", pid), []byte(strconv.Itoa(nice)), 0644,
+	)
+}
+
+// GetTimerSlack returns the timer slack of pid, in nanoseconds.
+func GetTimerSlack(pid int) (int, error) {
+	data, err := GetResource(pid, "timerslack_ns")
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// SetTimerSlack sets the timer slack of pid, in nanoseconds. Zero restores
+// the default value.
+func SetTimerSlack(pid, ns int) error {
+	return ioutil.WriteFile(
+		fmt.Sprintf("/proc/%d/timerslack_ns", pid), []byte(strconv.Itoa(ns)), 0644,
+	)
+}
+
+// GetExe returns the path of the executable of pid.
+func GetExe(pid int) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+}
+
+// GetCmdline returns the command line of pid, arguments being separated by
+// spaces.
+func GetCmdline(pid int) (cmdline string, err error) {
+	data, err := GetResource(pid, "cmdline")
+	if err == nil {
+		cmdline = strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " "))
+	}
+	return
+}
+
+// LoadMask selects the fields read by NewProc, NewProcFromStat and
+// FilteredProcs, besides the stat fields that are always loaded.
+type LoadMask uint
+
+const (
+	LoadStat        LoadMask = 1 << iota // /proc/[pid]/stat, always loaded
+	LoadUid                              // Uid and Gid, -1 when not loaded
+	LoadUser                             // owner and group, implies LoadUid
+	LoadCgroup                           // Cgroup
+	LoadOomScoreAdj                      // OomScoreAdj
+	LoadIOPrio                           // IOPrioClass and IOPrioData
+	LoadAutogroup                        // Autogroup and AutogroupNice
+	LoadTimerSlack                       // TimerSlack
+	// LoadAll leaves out LoadAutogroup and LoadTimerSlack, that are opt-in.
+	LoadAll = LoadStat | LoadUid | LoadUser | LoadCgroup | LoadOomScoreAdj |
+		LoadIOPrio
+)
+
+// getLoadMask returns the union of masks, defaulting to LoadAll.
+func getLoadMask(masks []LoadMask) (load LoadMask) {
+	if len(masks) == 0 {
+		return LoadAll
+	}
+	for _, mask := range masks {
+		load |= mask
+	}
+	if load&LoadUser != 0 {
+		load |= LoadUid
+	}
+	return load | LoadStat
+}
+
 type Proc struct {
 	ProcStat
-	Uid         int       `json:"uid"`
-	owner       user.User `json:"-"`
-	Cgroup      [3]string `json:"cgroup"`
-	OomScoreAdj int       `json:"oom_score_adj"`
-	IOPrioClass int       `json:"ioprio_class"`
-	IOPrioData  int       `json:"ionice"`
+	Uid         int        `json:"uid"`
+	Gid         int        `json:"gid"`
+	owner       user.User  `json:"-"`
+	group       user.Group `json:"-"`
+	Cgroup      [3]string  `json:"cgroup"`
+	OomScoreAdj int        `json:"oom_score_adj"`
+	IOPrioClass int        `json:"ioprio_class"`
+	IOPrioData  int        `json:"ionice"`
+	// Autogroup, AutogroupNice and TimerSlack are -1 when not loaded or not
+	// available; AutogroupNice being a valid nice value, check Autogroup.
+	Autogroup     int      `json:"autogroup"`
+	AutogroupNice int      `json:"autogroup_nice"`
+	TimerSlack    int      `json:"timerslack_ns"`
+	Loaded        LoadMask `json:"loaded"`
+}
+
+// IsLoaded reports whether all the fields selected by mask were loaded.
+func (p *Proc) IsLoaded(mask LoadMask) bool {
+	return p.Loaded&mask == mask
+}
+
+func (p *Proc) setUid() (err error) {
+	p.Uid, p.Gid = GetOwner(p.Pid)
+	return
 }
 
 func (p *Proc) setUser() (err error) {
-	p.Uid = GetUid(p.Pid)
 	if owner, err := GetUser(p.Uid); err == nil {
 		p.owner = *owner
 	}
+	if group, err := GetGroup(p.Gid); err == nil {
+		p.group = *group
+	}
 	return
 }
 
@@ -119,18 +243,53 @@ func (p *Proc) setIOPrio() (err error) {
 	return
 }
 
+func (p *Proc) setAutogroup() (err error) {
+	if id, nice, err := GetAutogroup(p.Pid); err == nil {
+		p.Autogroup, p.AutogroupNice = id, nice
+	}
+	return
+}
+
+func (p *Proc) setTimerSlack() (err error) {
+	if ns, err := GetTimerSlack(p.Pid); err == nil {
+		p.TimerSlack = ns
+	}
+	return
+}
+
 type setter = func() error
 
-func (p *Proc) setters() []setter {
-	return []setter{p.setUser, p.setCgroup, p.setOomScoreAdj, p.setIOPrio}
+func (p *Proc) setters(load LoadMask) (result []setter) {
+	p.Loaded = load
+	p.Uid, p.Gid = -1, -1
+	p.Autogroup, p.AutogroupNice, p.TimerSlack = -1, -1, -1
+	for _, s := range []struct {
+		mask     LoadMask
+		function setter
+	}{
+		{LoadUid, p.setUid},
+		{LoadUser, p.setUser},
+		{LoadCgroup, p.setCgroup},
+		{LoadOomScoreAdj, p.setOomScoreAdj},
+		{LoadIOPrio, p.setIOPrio},
+		{LoadAutogroup, p.setAutogroup},
+		{LoadTimerSlack, p.setTimerSlack},
+	} {
+		if load&s.mask != 0 {
+			result = append(result, s.function)
+		}
+	}
+	return
 }
 
-func NewProc(pid int) *Proc {
+// NewProc returns the Proc for pid, loading the fields selected by load,
+// or all of them when omitted.
+func NewProc(pid int, load ...LoadMask) *Proc {
 	p := &Proc{ProcStat: ProcStat{Pid: pid}}
 	if err := p.ProcStat.Read(pid); err != nil {
 		panic(err)
 	}
-	for _, function := range p.setters() {
+	for _, function := range p.setters(getLoadMask(load)) {
 		if err := function(); err != nil {
 			panic(err)
 		}
@@ -142,7 +301,9 @@ func GetCalling() *Proc {
 	return NewProc(os.Getpid())
 }
 
-func NewProcFromStat(stat string) (p *Proc, err error) {
+// NewProcFromStat returns the Proc for the content of a /proc/[pid]/stat
+// file, loading the fields selected by load, or all of them when omitted.
+func NewProcFromStat(stat string, load ...LoadMask) (p *Proc, err error) {
 	p = new(Proc)
 	// Stat
 	err = p.ProcStat.Load(stat)
@@ -150,7 +311,7 @@ func NewProcFromStat(stat string) (p *Proc, err error) {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		return
 	}
-	for _, function := range p.setters() {
+	for _, function := range p.setters(getLoadMask(load)) {
 		if err = function(); err != nil {
 			break
 		}
@@ -160,15 +321,15 @@ func NewProcFromStat(stat string) (p *Proc, err error) {
 
 func (p *Proc) GoString() string {
 	return "Proc" + fmt.Sprintf(
-		"{ProcStat: %s, Uid: %v, owner: %+v, Cgroup: %v, RTPrio: %v, Policy: %v, OomScoreAdj: %v, IOPrioData: %v, IOPrioClass: %v}",
-		p.ProcStat.GoString(), p.Uid, p.owner, p.Cgroup, p.RTPrio, p.Policy, p.OomScoreAdj, p.IOPrioData, p.IOPrioClass,
+		"{ProcStat: %s, Uid: %v, Gid: %v, owner: %+v, group: %+v, Cgroup: %v, RTPrio: %v, Policy: %v, OomScoreAdj: %v, IOPrioData: %v, IOPrioClass: %v, Autogroup: %v, AutogroupNice: %v, TimerSlack: %v}",
+		p.ProcStat.GoString(), p.Uid, p.Gid, p.owner, p.group, p.Cgroup, p.RTPrio, p.Policy, p.OomScoreAdj, p.IOPrioData, p.IOPrioClass, p.Autogroup, p.AutogroupNice, p.TimerSlack,
 	)
 }
 
 func (p *Proc) String() string {
 	return fmt.Sprintf(
-		"{ProcStat: %s, Uid: %v, owner: %+v, Cgroup: %v, RTPrio: %v, Policy: %v, OomScoreAdj: %v, IOPrioData: %v, IOPrioClass: %v}",
-		p.ProcStat.String(), p.Uid, p.owner, p.Cgroup, p.RTPrio, p.Policy, p.OomScoreAdj, p.IOPrioData, p.IOPrioClass,
+		"{ProcStat: %s, Uid: %v, Gid: %v, owner: %+v, group: %+v, Cgroup: %v, RTPrio: %v, Policy: %v, OomScoreAdj: %v, IOPrioData: %v, IOPrioClass: %v, Autogroup: %v, AutogroupNice: %v, TimerSlack: %v}",
+		p.ProcStat.String(), p.Uid, p.Gid, p.owner, p.group, p.Cgroup, p.RTPrio, p.Policy, p.OomScoreAdj, p.IOPrioData, p.IOPrioClass, p.Autogroup, p.AutogroupNice, p.TimerSlack,
 	)
 }
 
@@ -200,6 +361,9 @@ func (p Proc) Raw() string {
 			p.OomScoreAdj,
 			p.IOPrioClass,
 			p.IOPrioData,
+			p.Autogroup,
+			p.AutogroupNice,
+			p.TimerSlack,
 		),
 		"\n",
 	)
@@ -226,7 +390,7 @@ func (p *Proc) IOSchedInfo() string {
 }
 
 func (p *Proc) Values() string {
-	return fmt.Sprintf("[%d,%d,%d,%d,%q,%q,%q,%q,%q,%q,%d,%d,%d,%d,%d,%d,%q,%d]",
+	return fmt.Sprintf("[%d,%d,%d,%d,%q,%q,%q,%q,%q,%q,%d,%d,%d,%d,%d,%d,%q,%d,%d,%d,%d]",
 		p.Pid,
 		p.Ppid,
 		p.Pgrp,
@@ -245,6 +409,9 @@ func (p *Proc) Values() string {
 		p.OomScoreAdj,
 		p.IOClass(),
 		p.IOPrioData,
+		p.Autogroup,
+		p.AutogroupNice,
+		p.TimerSlack,
 	)
 }
 
@@ -261,6 +428,11 @@ func (p *Proc) Username() string {
 	return p.owner.Username
 }
 
+// Groupname returns the name of the process effective group.
+func (p *Proc) Groupname() string {
+	return p.group.Name
+}
+
 func (p *Proc) InUserSlice() bool {
 	return p.Cgroup[1] == "user.slice"
 }
@@ -269,6 +441,39 @@ func (p *Proc) InSystemSlice() bool {
 	return !(p.InUserSlice())
 }
 
+// CgroupPath returns the path of the process in the cgroup hierarchy
+// managed by systemd, preferring the unified hierarchy.
+func (p *Proc) CgroupPath() string {
+	return ParseCgroupPath(p.Cgroup[0])
+}
+
+// ParseCgroupPath returns the systemd managed path found in the content of
+// a /proc/[pid]/cgroup file.
+func ParseCgroupPath(cgroup string) string {
+	var unified, named string
+	for _, line := range strings.Split(cgroup, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			unified = parts[2]
+		case parts[1] == "name=systemd":
+			named = parts[2]
+		}
+	}
+	switch {
+	case len(unified) > 0 && unified != "/":
+		return unified
+	case len(named) > 0:
+		return named
+	case len(unified) > 0:
+		return unified
+	}
+	return "/"
+}
+
 func Stat(stat string) (result string) {
 	if p, err := NewProcFromStat(stat); err != nil {
 		panic(err)
@@ -300,54 +505,80 @@ func (s ProcByPid) Len() int           { return len(s) }
 func (s ProcByPid) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s ProcByPid) Less(i, j int) bool { return s[i].Pid < s[j].Pid }
 
-// FilteredProcs returns a slice of Proc for filtered processes.
-func FilteredProcs(filter Filterer) (result []*Proc) {
-	files, _ := filepath.Glob("/proc/[0-9]*/stat")
-	size := len(files)
-	// make our channels for communicating work and results
-	stats := make(chan string, size)
-	procs := make(chan *Proc, size)
-	// spin up workers and use a sync.WaitGroup to indicate completion
-	var count = runtime.GOMAXPROCS(0)
-	var wg sync.WaitGroup
-	for i := 0; i < count; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			var p *Proc
-			var err error
-			for stat := range stats {
-				p, err = NewProcFromStat(stat)
-				if filter.Filter(p, err) {
-					procs <- p
-				}
-			}
-		}()
-	}
-	// start sending jobs
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for _, file := range files {
-			data, err := ioutil.ReadFile(file)
-			if err == nil {
-				stats <- string(data)
-			}
-		}
-		close(stats)
-	}()
-	// wait on the workers to finish and close the procs channel
-	// to signal downstream that all work is done
-	go func() {
-		defer close(procs)
-		wg.Wait()
-	}()
+// scanOptions holds the settings applied by FilteredProcs, WalkProcs and
+// StreamProcs.
+type scanOptions struct {
+	sortKeys []string
+	workers  int
+	load     LoadMask
+}
+
+// ScanOption configures FilteredProcs, WalkProcs and StreamProcs.
+type ScanOption func(o *scanOptions)
+
+func getScanOptions(options []ScanOption) (opts scanOptions) {
+	opts.workers = runtime.GOMAXPROCS(0)
+	opts.load = LoadAll
+	for _, option := range options {
+		option(&opts)
+	}
+	if opts.workers < 1 {
+		opts.workers = 1
+	}
+	return
+}
+
+// SortBy orders the result of FilteredProcs with the given keys, as
+// understood by SortProcs. Default ordering is by Pid.
+func SortBy(keys ...string) ScanOption {
+	return func(o *scanOptions) {
+		o.sortKeys = append(o.sortKeys, keys...)
+	}
+}
+
+// Workers sets the number of goroutines reading processes. Default is
+// runtime.GOMAXPROCS(0).
+func Workers(count int) ScanOption {
+	return func(o *scanOptions) {
+		o.workers = count
+	}
+}
+
+// Load selects the fields read for each process. Default is LoadAll. Note
+// that filters only see the loaded fields.
+func Load(masks ...LoadMask) ScanOption {
+	return func(o *scanOptions) {
+		o.load = getLoadMask(masks)
+	}
+}
+
+// ScanProcs returns a slice of Proc for filtered processes, failing before
+// any read when a SortBy key is invalid.
+func ScanProcs(filter Filterer, options ...ScanOption) (result []*Proc, err error) {
+	opts := getScanOptions(options)
+	// check keys first
+	if _, err = NewProcSorter(nil, opts.sortKeys...); err != nil {
+		return nil, err
+	}
 	// collect result from procs channel
-	for p := range procs {
+	for p := range StreamProcs(context.Background(), filter, options...) {
 		result = append(result, p)
 	}
-	// sort by Pid
-	sort.Sort(ProcByPid(result))
+	// sort by keys, defaulting to Pid
+	err = SortProcs(result, opts.sortKeys...)
+	return
+}
+
+// FilteredProcs returns a slice of Proc for filtered processes. Processes
+// are sorted by Pid when a SortBy key is invalid; use ScanProcs to get the
+// error instead.
+func FilteredProcs(filter Filterer, options ...ScanOption) (result []*Proc) {
+	opts := getScanOptions(options)
+	if _, err := NewProcSorter(nil, opts.sortKeys...); err != nil {
+		// drop the invalid keys
+		options = append(options, func(o *scanOptions) { o.sortKeys = nil })
+	}
+	result, _ = ScanProcs(filter, options...)
 	return
 }
 
@@ -0,0 +1,94 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProcOptInFields(t *testing.T) {
+	stat, err := ReadStat(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	slack, err := GetTimerSlack(os.Getpid())
+	if err != nil {
+		t.Skip(err)
+	}
+	if slack <= 1 {
+		t.Skipf("timer slack %d", slack)
+	}
+	s := Settings{TimerSlack: IntPtr(1)}
+
+	p, err := NewProcFromStat(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IsLoaded(LoadAutogroup) || p.IsLoaded(LoadTimerSlack) {
+		t.Errorf("opt-in fields loaded by default: %v", p.Loaded)
+	}
+	if p.Autogroup != -1 || p.AutogroupNice != -1 || p.TimerSlack != -1 {
+		t.Errorf("got autogroup %d, autogroup nice %d, timer slack %d, want -1",
+			p.Autogroup, p.AutogroupNice, p.TimerSlack)
+	}
+	// fields not loaded are read on demand
+	actions := s.Plan(p)
+	if len(actions) != 1 || actions[0].Field != "timerslack_ns" || actions[0].Old != slack {
+		t.Errorf("Plan returned %v, want a timerslack_ns change from %d", actions, slack)
+	}
+	if actions := (Settings{TimerSlack: IntPtr(slack)}).Plan(p); len(actions) > 0 {
+		t.Errorf("Plan returned %v for the current timer slack", actions)
+	}
+
+	if p, err = NewProcFromStat(stat, s.loadMask()); err != nil {
+		t.Fatal(err)
+	}
+	if p.TimerSlack != slack {
+		t.Errorf("got timer slack %d, want %d", p.TimerSlack, slack)
+	}
+	actions = s.Plan(p)
+	if len(actions) != 1 || actions[0].Field != "timerslack_ns" {
+		t.Errorf("Plan returned %v, want a timerslack_ns change", actions)
+	}
+	for _, text := range []string{p.String(), p.GoString()} {
+		if !strings.Contains(text, "TimerSlack: ") || !strings.Contains(text, "AutogroupNice: ") {
+			t.Errorf("missing fields in %s", text)
+		}
+	}
+	text := fmt.Sprint(p.TimerSlack)
+	if !strings.HasSuffix(p.Raw(), " "+text) || !strings.HasSuffix(p.Values(), ","+text+"]") {
+		t.Errorf("Raw %q or Values %q not ending with the timer slack", p.Raw(), p.Values())
+	}
+}
+
+func TestEngineLoadMask(t *testing.T) {
+	engine, err := NewEngine(
+		&Rule{Name: "a", Settings: Settings{Nice: IntPtr(1)}},
+		&Rule{Name: "b", Settings: Settings{TimerSlack: IntPtr(1)}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if load := engine.LoadMask(); load != LoadAll|LoadTimerSlack {
+		t.Errorf("got %v, want %v", load, LoadAll|LoadTimerSlack)
+	}
+}
@@ -21,8 +21,14 @@ package goprocfs
 import (
 	"fmt"
 	// "encoding/json"
+	"io"
 	"io/ioutil"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 // See the following discussions:
@@ -30,7 +36,10 @@ import (
 // - https://github.com/prometheus/node_exporter/issues/52
 // - https://github.com/prometheus/procfs/pull/2
 // - http://stackoverflow.com/questions/17410841/how-does-user-hz-solve-the-jiffy-scaling-issue
-const userHZ = 100
+//
+// userHZ is read from the AT_CLKTCK entry of the auxiliary vector, falling
+// back to the usual value of 100.
+var userHZ = getClockTicks()
 
 var (
 	stProcStat string
@@ -156,75 +165,158 @@ type ProcStat struct {
 	ExitCode            int    `json:"exit_code"`             // (52) %d
 }
 
+// statParser reads the space separated fields of a stat buffer without
+// allocating.
+type statParser struct {
+	buffer string
+	err    error
+}
+
+func (sp *statParser) next() (field string) {
+	if sp.err != nil {
+		return
+	}
+	sp.buffer = strings.TrimLeft(sp.buffer, " \n")
+	if len(sp.buffer) == 0 {
+		sp.err = io.ErrUnexpectedEOF
+		return
+	}
+	if i := strings.IndexAny(sp.buffer, " \n"); i >= 0 {
+		field, sp.buffer = sp.buffer[:i], sp.buffer[i:]
+	} else {
+		field, sp.buffer = sp.buffer, ""
+	}
+	return
+}
+
+func (sp *statParser) int64() (value int64) {
+	if field := sp.next(); sp.err == nil {
+		value, sp.err = strconv.ParseInt(field, 10, 64)
+	}
+	return
+}
+
+func (sp *statParser) uint64() (value uint64) {
+	if field := sp.next(); sp.err == nil {
+		value, sp.err = strconv.ParseUint(field, 10, 64)
+	}
+	return
+}
+
+func (sp *statParser) int() int   { return int(sp.int64()) }
+func (sp *statParser) uint() uint { return uint(sp.uint64()) }
+
 func (stat *ProcStat) Load(buffer string) (err error) {
 	stat.stat = buffer
-	var comm string
+	// comm may hold spaces and parentheses
+	lparen := strings.IndexByte(buffer, '(')
+	rparen := strings.LastIndexByte(buffer, ')')
+	if lparen < 0 || rparen < lparen {
+		return fmt.Errorf("invalid stat: %q", buffer)
+	}
+	if stat.Pid, err = strconv.Atoi(strings.TrimSpace(buffer[:lparen])); err != nil {
+		return
+	}
+	stat.Comm = buffer[lparen+1 : rparen]
 	// parse
-	_, err = fmt.Sscan(
-		buffer,
-		&stat.Pid, // (1) %d *
-		&comm,
-		// &stat.Comm,				// (2) %s *
-		&stat.State,               // (3) %c *
-		&stat.Ppid,                // (4) %d *
-		&stat.Pgrp,                // (5) %d *
-		&stat.Session,             // (6) %d
-		&stat.TtyNr,               // (7) %d
-		&stat.TPGid,               // (8) %d
-		&stat.Flags,               // (9) %u
-		&stat.MinFlt,              // (10) %lu
-		&stat.CMinFlt,             // (11) %lu
-		&stat.MajFlt,              // (12) %lu
-		&stat.CMajFlt,             // (13) %lu
-		&stat.UTime,               // (14) %lu
-		&stat.STime,               // (15) %lu
-		&stat.CUTime,              // (16) %ld
-		&stat.CSTime,              // (17) %ld
-		&stat.Priority,            // (18) %ld *
-		&stat.Nice,                // (19) %ld *
-		&stat.NumThreads,          // (20) %ld *
-		&stat.ITRealValue,         // (21) %ld
-		&stat.StartTime,           // (22) %llu
-		&stat.VSize,               // (23) %lu
-		&stat.Rss,                 // (24) %ld
-		&stat.RssLim,              // (25) %lu
-		&stat.StartCode,           // (26) %lu
-		&stat.EndCode,             // (27) %lu
-		&stat.StartStack,          // (28) %lu
-		&stat.KStkESP,             // (29) %lu
-		&stat.KStkEIP,             // (30) %lu
-		&stat.Signal,              // (31) %lu
-		&stat.Blocked,             // (32) %lu
-		&stat.SigIgnore,           // (33) %lu
-		&stat.SigCatch,            // (34) %lu
-		&stat.WChan,               // (35) %lu
-		&stat.NSwap,               // (36) %lu -
-		&stat.CNSwap,              // (37) %lu -
-		&stat.ExitSignal,          // (38) %d
-		&stat.Processor,           // (39) %d
-		&stat.RTPrio,              // (40) %u *
-		&stat.Policy,              // (41) %u *
-		&stat.DelayAcctBlkIOTicks, // (42) %llu
-		&stat.GuestTime,           // (43) %lu
-		&stat.CGuestTime,          // ((44) %ld
-		&stat.StartData,           // (45) %lu
-		&stat.EndData,             // (46) %lu
-		&stat.StartBrk,            // (47) %lu
-		&stat.ArgStart,            // (48) %lu
-		&stat.ArgEnd,              // (49) %lu
-		&stat.EnvStart,            // (50) %lu
-		&stat.EnvEnd,              // (51) %lu
-		&stat.ExitCode,            // (52) %d
-	)
-	stat.Comm = strings.Trim(comm, "()")
+	sp := &statParser{buffer: buffer[rparen+1:]}
+	stat.State = sp.next()                 // (3) %c *
+	stat.Ppid = sp.int()                   // (4) %d *
+	stat.Pgrp = sp.int()                   // (5) %d *
+	stat.Session = sp.int()                // (6) %d
+	stat.TtyNr = sp.int()                  // (7) %d
+	stat.TPGid = sp.int()                  // (8) %d
+	stat.Flags = sp.uint()                 // (9) %u
+	stat.MinFlt = sp.uint()                // (10) %lu
+	stat.CMinFlt = sp.uint()               // (11) %lu
+	stat.MajFlt = sp.uint()                // (12) %lu
+	stat.CMajFlt = sp.uint()               // (13) %lu
+	stat.UTime = sp.uint()                 // (14) %lu
+	stat.STime = sp.uint()                 // (15) %lu
+	stat.CUTime = sp.int()                 // (16) %ld
+	stat.CSTime = sp.int()                 // (17) %ld
+	stat.Priority = sp.int()               // (18) %ld *
+	stat.Nice = sp.int()                   // (19) %ld *
+	stat.NumThreads = sp.int()             // (20) %ld *
+	stat.ITRealValue = sp.int()            // (21) %ld
+	stat.StartTime = sp.uint64()           // (22) %llu
+	stat.VSize = sp.uint()                 // (23) %lu
+	stat.Rss = sp.int()                    // (24) %ld
+	stat.RssLim = sp.uint()                // (25) %lu
+	stat.StartCode = sp.uint()             // (26) %lu
+	stat.EndCode = sp.uint()               // (27) %lu
+	stat.StartStack = sp.uint()            // (28) %lu
+	stat.KStkESP = sp.uint()               // (29) %lu
+	stat.KStkEIP = sp.uint()               // (30) %lu
+	stat.Signal = sp.uint()                // (31) %lu
+	stat.Blocked = sp.uint()               // (32) %lu
+	stat.SigIgnore = sp.uint()             // (33) %lu
+	stat.SigCatch = sp.uint()              // (34) %lu
+	stat.WChan = sp.uint()                 // (35) %lu
+	stat.NSwap = sp.uint()                 // (36) %lu -
+	stat.CNSwap = sp.uint()                // (37) %lu -
+	stat.ExitSignal = sp.int()             // (38) %d
+	stat.Processor = sp.int()              // (39) %d
+	stat.RTPrio = sp.int()                 // (40) %u *
+	stat.Policy = sp.int()                 // (41) %u *
+	stat.DelayAcctBlkIOTicks = sp.uint64() // (42) %llu
+	stat.GuestTime = sp.uint()             // (43) %lu
+	stat.CGuestTime = sp.int()             // ((44) %ld
+	stat.StartData = sp.uint()             // (45) %lu
+	stat.EndData = sp.uint()               // (46) %lu
+	stat.StartBrk = sp.uint()              // (47) %lu
+	stat.ArgStart = sp.uint()              // (48) %lu
+	stat.ArgEnd = sp.uint()                // (49) %lu
+	stat.EnvStart = sp.uint()              // (50) %lu
+	stat.EnvEnd = sp.uint()                // (51) %lu
+	stat.ExitCode = sp.int()               // (52) %d
+	return sp.err
+}
+
+// statBuffers holds the reusable buffers used to read stat files.
+var statBuffers = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, 4096)
+		return &buffer
+	},
+}
+
+// ReadStat returns the content of /proc/[pid]/stat, read into a pooled
+// buffer.
+func ReadStat(pid int) (stat string, err error) {
+	buffer := statBuffers.Get().(*[]byte)
+	defer statBuffers.Put(buffer)
+	path := append((*buffer)[:0], "/proc/"...)
+	path = strconv.AppendInt(path, int64(pid), 10)
+	path = append(path, "/stat"...)
+	fd, err := unix.Open(string(path), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+	var n, count int
+	for {
+		if count == len(*buffer) {
+			*buffer = append(*buffer, make([]byte, len(*buffer))...)
+		}
+		n, err = unix.Read(fd, (*buffer)[count:])
+		if n <= 0 || err != nil {
+			break
+		}
+		count += n
+	}
+	if err == nil {
+		stat = string((*buffer)[:count])
+	}
 	return
 }
 
 func (stat *ProcStat) Read(pid int) (err error) {
 	// read stat data for pid
-	if data, err := GetResource(pid, "stat"); err == nil {
+	var data string
+	if data, err = ReadStat(pid); err == nil {
 		// load
-		err = stat.Load(string(data))
+		err = stat.Load(data)
 	}
 	return
 }
@@ -291,9 +383,44 @@ func (stat *ProcStat) String() string {
 	)
 }
 
-// CPUTime returns the total CPU user and system time in seconds.
-func (stat *ProcStat) CPUTime() float64 {
-	return float64(stat.UTime+stat.STime) / userHZ
+// ticksToDuration converts clock ticks into a time.Duration, splitting
+// whole seconds from the remainder so that large tick counts do not
+// overflow.
+func ticksToDuration(ticks uint64) time.Duration {
+	return time.Duration(ticks/userHZ)*time.Second +
+		time.Duration(ticks%userHZ)*time.Second/time.Duration(userHZ)
+}
+
+// CPUTime returns the total CPU user and system time.
+func (stat *ProcStat) CPUTime() time.Duration {
+	return ticksToDuration(uint64(stat.UTime + stat.STime))
+}
+
+// StartedAt returns the time the process started, or the zero time when
+// the boot time is unknown.
+func (stat *ProcStat) StartedAt() time.Time {
+	btime, err := GetBootTime()
+	if err != nil {
+		return time.Time{}
+	}
+	return btime.Add(ticksToDuration(stat.StartTime))
+}
+
+// Age returns the time elapsed since the process started.
+func (stat *ProcStat) Age() time.Duration {
+	if started := stat.StartedAt(); !started.IsZero() {
+		return time.Since(started)
+	}
+	return 0
+}
+
+// CPUUsage returns the CPU time used by the process over its lifetime, as
+// a percentage of a single CPU.
+func (stat *ProcStat) CPUUsage() float64 {
+	if age := stat.Age(); age > 0 {
+		return float64(stat.CPUTime()) / float64(age) * 100
+	}
+	return 0
 }
 
 // vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,159 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleStat = "14066 (nvim) S 14064 14063 14063 0 -1 4194304 5898 6028 495 394 487 64 88 68 39 19 1 0 1256778 18685952 2655 4294967295 4620288 7319624 3219630688 0 0 0 0 2 536891909 1 0 0 17 0 0 0 0 0 0 8366744 8490776 38150144 3219638342 3219638506 3219638506 3219644398 0\n"
+
+func TestProcStatLoad(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		buffer    string
+		pid       int
+		comm      string
+		starttime uint64
+		exitCode  int
+		fails     bool
+	}{
+		{"plain", sampleStat, 14066, "nvim", 1256778, 0, false},
+		{
+			"comm with spaces and parentheses",
+			strings.Replace(sampleStat, "(nvim)", "(Web Content) (x))", 1),
+			14066, "Web Content) (x)", 1256778, 0, false,
+		},
+		{
+			"exit code",
+			strings.TrimSuffix(sampleStat, "0\n") + "137\n",
+			14066, "nvim", 1256778, 137, false,
+		},
+		{"truncated line", sampleStat[:len(sampleStat)/2], 0, "", 0, 0, true},
+		{"no comm", "14066 nvim S 1 1 1", 0, "", 0, 0, true},
+		{"invalid pid", strings.Replace(sampleStat, "14066", "x14066", 1), 0, "", 0, 0, true},
+		{"invalid field", strings.Replace(sampleStat, " 1256778 ", " 12x56778 ", 1), 0, "", 0, 0, true},
+		{"empty", "", 0, "", 0, 0, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stat ProcStat
+			err := stat.Load(tt.buffer)
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("Load(%q) succeeded", tt.buffer)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.Pid != tt.pid || stat.Comm != tt.comm || stat.StartTime != tt.starttime ||
+				stat.ExitCode != tt.exitCode {
+				t.Errorf("got pid %d, comm %q, starttime %d, exit code %d",
+					stat.Pid, stat.Comm, stat.StartTime, stat.ExitCode)
+			}
+			if stat.State != "S" || stat.Ppid != 14064 || stat.Nice != 19 || stat.Policy != 0 {
+				t.Errorf("got state %q, ppid %d, nice %d, policy %d",
+					stat.State, stat.Ppid, stat.Nice, stat.Policy)
+			}
+		})
+	}
+}
+
+// sscanLoad is the former ProcStat.Load, kept to measure the gain of
+// statParser.
+func sscanLoad(stat *ProcStat, buffer string) (err error) {
+	var comm string
+	_, err = fmt.Sscan(
+		buffer,
+		&stat.Pid, &comm, &stat.State, &stat.Ppid, &stat.Pgrp, &stat.Session,
+		&stat.TtyNr, &stat.TPGid, &stat.Flags, &stat.MinFlt, &stat.CMinFlt,
+		&stat.MajFlt, &stat.CMajFlt, &stat.UTime, &stat.STime, &stat.CUTime,
+		&stat.CSTime, &stat.Priority, &stat.Nice, &stat.NumThreads,
+		&stat.ITRealValue, &stat.StartTime, &stat.VSize, &stat.Rss, &stat.RssLim,
+		&stat.StartCode, &stat.EndCode, &stat.StartStack, &stat.KStkESP,
+		&stat.KStkEIP, &stat.Signal, &stat.Blocked, &stat.SigIgnore,
+		&stat.SigCatch, &stat.WChan, &stat.NSwap, &stat.CNSwap, &stat.ExitSignal,
+		&stat.Processor, &stat.RTPrio, &stat.Policy, &stat.DelayAcctBlkIOTicks,
+		&stat.GuestTime, &stat.CGuestTime, &stat.StartData, &stat.EndData,
+		&stat.StartBrk, &stat.ArgStart, &stat.ArgEnd, &stat.EnvStart,
+		&stat.EnvEnd, &stat.ExitCode,
+	)
+	stat.Comm = strings.Trim(comm, "()")
+	return
+}
+
+func BenchmarkProcStatLoad(b *testing.B) {
+	b.Run("statParser", func(b *testing.B) {
+		var stat ProcStat
+		for i := 0; i < b.N; i++ {
+			if err := stat.Load(sampleStat); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Sscan", func(b *testing.B) {
+		var stat ProcStat
+		for i := 0; i < b.N; i++ {
+			if err := sscanLoad(&stat, sampleStat); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// globProcs is the former enumeration of FilteredProcs: Glob, ReadFile and
+// fmt.Sscan.
+func globProcs() (result []*ProcStat) {
+	files, _ := filepath.Glob("/proc/[0-9]*/stat")
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		stat := &ProcStat{}
+		if err := sscanLoad(stat, string(data)); err == nil {
+			result = append(result, stat)
+		}
+	}
+	return
+}
+
+// Both paths run on a single goroutine, reading the stat fields only.
+func BenchmarkFilteredProcs(b *testing.B) {
+	filter := GetFilterer("all")
+	b.Run("getdents", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if len(FilteredProcs(filter, Workers(1), Load(LoadStat))) == 0 {
+				b.Fatal("no process found")
+			}
+		}
+	})
+	b.Run("Glob", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if len(globProcs()) == 0 {
+				b.Fatal("no process found")
+			}
+		}
+	})
+}
@@ -0,0 +1,202 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Matcher selects processes. Each non empty field is a regular expression
+// that must match the whole value; all of them must match.
+type Matcher struct {
+	Comm    string `json:"comm,omitempty"`
+	Exe     string `json:"exe,omitempty"`
+	Cmdline string `json:"cmdline,omitempty"`
+	Cgroup  string `json:"cgroup,omitempty"`
+	// User matches the owner name, or uid when it has no name.
+	User string `json:"user,omitempty"`
+}
+
+// compiledMatcher holds the regular expressions of a Matcher.
+type compiledMatcher struct {
+	comm, exe, cmdline, cgroup, user *regexp.Regexp
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) == 0 {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+func (m Matcher) compile() (c compiledMatcher, err error) {
+	for _, field := range []struct {
+		pattern string
+		re      **regexp.Regexp
+	}{
+		{m.Comm, &c.comm},
+		{m.Exe, &c.exe},
+		{m.Cmdline, &c.cmdline},
+		{m.Cgroup, &c.cgroup},
+		{m.User, &c.user},
+	} {
+		if *field.re, err = compilePattern(field.pattern); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (c compiledMatcher) match(p *Proc) bool {
+	if c.comm != nil && !c.comm.MatchString(p.Comm) {
+		return false
+	}
+	if c.cgroup != nil && !c.cgroup.MatchString(p.CgroupPath()) {
+		return false
+	}
+	if c.user != nil {
+		name := p.Username()
+		if len(name) == 0 {
+			name = strconv.Itoa(p.Uid)
+		}
+		if !c.user.MatchString(name) {
+			return false
+		}
+	}
+	// exe and cmdline are read last, only when needed
+	if c.exe != nil {
+		if exe, err := GetExe(p.Pid); err != nil || !c.exe.MatchString(exe) {
+			return false
+		}
+	}
+	if c.cmdline != nil {
+		if cmdline, err := GetCmdline(p.Pid); err != nil || !c.cmdline.MatchString(cmdline) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rule applies Settings to the processes selected by Matcher.
+type Rule struct {
+	Name string `json:"name"`
+	Matcher
+	Settings
+	compiled *compiledMatcher
+}
+
+// Compile checks the patterns of the rule. It is called by NewEngine.
+func (r *Rule) Compile() error {
+	c, err := r.Matcher.compile()
+	if err != nil {
+		return fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+	r.compiled = &c
+	return nil
+}
+
+// Match reports whether p is selected by the rule.
+func (r *Rule) Match(p *Proc) bool {
+	if r.compiled == nil {
+		if err := r.Compile(); err != nil {
+			return false
+		}
+	}
+	return r.compiled.match(p)
+}
+
+// Engine evaluates rules against processes, the first matching rule
+// winning.
+type Engine struct {
+	Rules []*Rule
+	// DryRun only reports the actions that would be taken.
+	DryRun bool
+	// Journal, when set, records the original values of the processes
+	// changed.
+	Journal *Journal
+}
+
+// NewEngine returns an Engine for rules, checking their patterns.
+func NewEngine(rules ...*Rule) (*Engine, error) {
+	for _, r := range rules {
+		if err := r.Compile(); err != nil {
+			return nil, err
+		}
+	}
+	return &Engine{Rules: rules}, nil
+}
+
+// LoadMask returns LoadAll with the opt-in fields needed by the rules.
+func (e *Engine) LoadMask() LoadMask {
+	load := LoadAll
+	for _, r := range e.Rules {
+		load |= r.Settings.loadMask()
+	}
+	return load
+}
+
+// Match returns the first rule matching p, or nil.
+func (e *Engine) Match(p *Proc) *Rule {
+	for _, r := range e.Rules {
+		if r.Match(p) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Evaluate returns the actions for p, applied unless DryRun is set.
+func (e *Engine) Evaluate(p *Proc) []Action {
+	r := e.Match(p)
+	if r == nil {
+		return nil
+	}
+	return e.evaluate(p, r)
+}
+
+// evaluate returns the actions of the rule r for p.
+func (e *Engine) evaluate(p *Proc, r *Rule) (actions []Action) {
+	if e.DryRun {
+		actions = r.Settings.Plan(p)
+	} else {
+		actions = r.Settings.Apply(p)
+		if e.Journal != nil {
+			e.Journal.Record(p, actions)
+		}
+	}
+	for i := range actions {
+		actions[i].Rule = r.Name
+	}
+	return
+}
+
+// Run evaluates the rules against the filtered processes and returns the
+// actions taken, or that would be taken when DryRun is set. The fields
+// selected by LoadMask are read, unless options hold a Load option.
+func (e *Engine) Run(filter Filterer, options ...ScanOption) (actions []Action) {
+	options = append([]ScanOption{Load(e.LoadMask())}, options...)
+	for _, p := range FilteredProcs(filter, options...) {
+		actions = append(actions, e.Evaluate(p)...)
+	}
+	return
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,191 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	systemdBusName = "org.freedesktop.systemd1"
+	systemdPath    = dbus.ObjectPath("/org/freedesktop/systemd1")
+	// SystemdStartTransientUnit is the method called on the manager object.
+	SystemdStartTransientUnit = "org.freedesktop.systemd1.Manager.StartTransientUnit"
+)
+
+// UnitProperty is a property of a transient unit, as expected by
+// StartTransientUnit, signature "(sv)".
+type UnitProperty struct {
+	Name  string
+	Value dbus.Variant
+}
+
+// auxUnit is an auxiliary unit of StartTransientUnit, signature
+// "(sa(sv))". None is ever sent.
+type auxUnit struct {
+	Name       string
+	Properties []UnitProperty
+}
+
+// ScopeProperties holds the settings of a transient scope. Zero values are
+// left to the manager defaults.
+type ScopeProperties struct {
+	Description string `json:"description,omitempty"`
+	// Slice holding the scope, e.g. "background.slice".
+	Slice string `json:"slice,omitempty"`
+	// CPUWeight and IOWeight are in [1, 10000], 100 being the default.
+	CPUWeight uint64 `json:"cpu_weight,omitempty"`
+	IOWeight  uint64 `json:"io_weight,omitempty"`
+	// MemoryHigh and MemoryMax are in bytes.
+	MemoryHigh uint64 `json:"memory_high,omitempty"`
+	MemoryMax  uint64 `json:"memory_max,omitempty"`
+	// Delegate lets the processes manage the cgroup subtree of the scope.
+	Delegate bool `json:"delegate,omitempty"`
+}
+
+// properties returns the unit properties for pids.
+func (sp ScopeProperties) properties(pids []int) (result []UnitProperty, err error) {
+	add := func(name string, value interface{}) {
+		result = append(result, UnitProperty{name, dbus.MakeVariant(value)})
+	}
+	pidList := make([]uint32, 0, len(pids))
+	for _, pid := range pids {
+		if pid <= 0 {
+			return nil, fmt.Errorf("invalid pid %d", pid)
+		}
+		pidList = append(pidList, uint32(pid))
+	}
+	add("PIDs", pidList)
+	if len(sp.Description) > 0 {
+		add("Description", sp.Description)
+	}
+	if len(sp.Slice) > 0 {
+		if !strings.HasSuffix(sp.Slice, ".slice") {
+			return nil, fmt.Errorf("invalid slice %q", sp.Slice)
+		}
+		add("Slice", sp.Slice)
+	}
+	for _, weight := range []struct {
+		name  string
+		value uint64
+	}{
+		{"CPUWeight", sp.CPUWeight},
+		{"IOWeight", sp.IOWeight},
+	} {
+		if weight.value == 0 {
+			continue
+		}
+		if weight.value > 10000 {
+			return nil, fmt.Errorf("%s %d out of range [1, 10000]", weight.name, weight.value)
+		}
+		add(weight.name, weight.value)
+	}
+	if sp.MemoryHigh > 0 {
+		add("MemoryHigh", sp.MemoryHigh)
+	}
+	if sp.MemoryMax > 0 {
+		add("MemoryMax", sp.MemoryMax)
+	}
+	if sp.Delegate {
+		add("Delegate", true)
+	}
+	return
+}
+
+// ScopeManager creates transient scopes through a systemd manager.
+type ScopeManager struct {
+	// object is the manager, or any stand-in implementing
+	// StartTransientUnit
+	object dbus.BusObject
+	// conn is the connection opened by ConnectScopeManager, if any
+	conn *dbus.Conn
+}
+
+// NewScopeManager returns a ScopeManager calling object, either the systemd
+// manager or a stand-in service exporting StartTransientUnit on the
+// org.freedesktop.systemd1.Manager interface.
+func NewScopeManager(object dbus.BusObject) *ScopeManager {
+	return &ScopeManager{object: object}
+}
+
+// ConnectScopeManager returns a ScopeManager for the systemd user manager,
+// on the session bus, or the system manager.
+func ConnectScopeManager(user bool) (*ScopeManager, error) {
+	connect := dbus.ConnectSystemBus
+	if user {
+		connect = dbus.ConnectSessionBus
+	}
+	conn, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	m := NewScopeManager(conn.Object(systemdBusName, systemdPath))
+	m.conn = conn
+	return m, nil
+}
+
+// Close closes the connection opened by ConnectScopeManager. The
+// connection of the object given to NewScopeManager is left to the caller.
+func (m *ScopeManager) Close() error {
+	if m.conn == nil {
+		return nil
+	}
+	return m.conn.Close()
+}
+
+// StartScope moves pids into the new transient scope name, adding the
+// ".scope" suffix when missing, and returns the path of the job queued by
+// the manager.
+func (m *ScopeManager) StartScope(name string, pids []int, sp ScopeProperties) (job dbus.ObjectPath, err error) {
+	if len(pids) == 0 {
+		return "", fmt.Errorf("scope %q: no pid", name)
+	}
+	if !strings.HasSuffix(name, ".scope") {
+		name += ".scope"
+	}
+	if len(name) == len(".scope") || strings.ContainsAny(name, "/ ") {
+		return "", fmt.Errorf("invalid scope name %q", name)
+	}
+	properties, err := sp.properties(pids)
+	if err != nil {
+		return "", fmt.Errorf("scope %q: %w", name, err)
+	}
+	err = m.object.Call(
+		SystemdStartTransientUnit, 0, name, "fail", properties, []auxUnit{},
+	).Store(&job)
+	if err != nil {
+		return "", fmt.Errorf("scope %q: %w", name, err)
+	}
+	return
+}
+
+// StartProcsScope moves the processes of procs into the new transient
+// scope name.
+func (m *ScopeManager) StartProcsScope(name string, procs []*Proc, sp ScopeProperties) (dbus.ObjectPath, error) {
+	pids := make([]int, 0, len(procs))
+	for _, p := range procs {
+		pids = append(pids, p.Pid)
+	}
+	return m.StartScope(name, pids, sp)
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
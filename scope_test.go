@@ -0,0 +1,173 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// busConfig is the configuration of a private bus allowing everything.
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startBus starts a private dbus-daemon and returns its address.
+func startBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(config, []byte(fmt.Sprintf(busConfig, filepath.Join(dir, "bus"))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(daemon, "--config-file="+config, "--print-address=1", "--nofork")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("dbus-daemon: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// transientCall holds the arguments of a StartTransientUnit call.
+type transientCall struct {
+	name, mode string
+	properties map[string]dbus.Variant
+	aux        []auxUnit
+}
+
+// fakeManager stands in for the systemd manager, sending each call from the
+// handler goroutine of godbus.
+type fakeManager struct {
+	calls chan transientCall
+}
+
+func (m *fakeManager) StartTransientUnit(name, mode string, properties []UnitProperty, aux []auxUnit) (dbus.ObjectPath, *dbus.Error) {
+	call := transientCall{name, mode, make(map[string]dbus.Variant), aux}
+	for _, p := range properties {
+		call.properties[p.Name] = p.Value
+	}
+	m.calls <- call
+	return "/org/freedesktop/systemd1/job/42", nil
+}
+
+func TestStartScope(t *testing.T) {
+	address := startBus(t)
+	service, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	manager := &fakeManager{calls: make(chan transientCall, 1)}
+	if err := service.Export(manager, systemdPath, "org.freedesktop.systemd1.Manager"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := service.RequestName(systemdBusName, dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatal(err)
+	} else if reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("name %s not owned: %v", systemdBusName, reply)
+	}
+
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+	m, err := ConnectScopeManager(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	job, err := m.StartScope("batch-1", []int{1234, 5678}, ScopeProperties{
+		Slice:      "background.slice",
+		CPUWeight:  20,
+		IOWeight:   30,
+		MemoryHigh: 1 << 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job != "/org/freedesktop/systemd1/job/42" {
+		t.Errorf("got job %q", job)
+	}
+	// the reply is sent once the call is recorded
+	var call transientCall
+	select {
+	case call = <-manager.calls:
+	default:
+		t.Fatal("no call recorded")
+	}
+	if call.name != "batch-1.scope" || call.mode != "fail" || len(call.aux) != 0 {
+		t.Errorf("got name %q, mode %q, aux %v", call.name, call.mode, call.aux)
+	}
+	pids := call.properties["PIDs"]
+	if pids.Signature().String() != "au" || !reflect.DeepEqual(pids.Value(), []uint32{1234, 5678}) {
+		t.Errorf("got PIDs %v", pids)
+	}
+	for name, want := range map[string]interface{}{
+		"Slice":      "background.slice",
+		"CPUWeight":  uint64(20),
+		"IOWeight":   uint64(30),
+		"MemoryHigh": uint64(1 << 30),
+	} {
+		if got, found := call.properties[name]; !found || !reflect.DeepEqual(got.Value(), want) {
+			t.Errorf("got %s %v, want %v", name, got, want)
+		}
+	}
+	for _, name := range []string{"MemoryMax", "Delegate", "Description"} {
+		if _, found := call.properties[name]; found {
+			t.Errorf("unset property %s sent", name)
+		}
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.StartScope("batch-2", []int{1234}, ScopeProperties{}); err == nil {
+		t.Error("StartScope succeeded after Close")
+	}
+}
@@ -0,0 +1,455 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Settings holds the scheduling values to apply to a process. Nil fields
+// are left unchanged.
+type Settings struct {
+	Nice        *int  `json:"nice,omitempty"`
+	SchedPolicy *int  `json:"sched,omitempty"`
+	RTPrio      *int  `json:"rtprio,omitempty"`
+	IOClass     *int  `json:"ioclass,omitempty"`
+	IONice      *int  `json:"ionice,omitempty"`
+	OomScoreAdj *int  `json:"oom_score_adj,omitempty"`
+	Affinity    []int `json:"affinity,omitempty"`
+	// AutogroupNice is shared by all the processes of the session.
+	AutogroupNice *int `json:"autogroup_nice,omitempty"`
+	// TimerSlack in nanoseconds, zero restoring the default value.
+	TimerSlack *int `json:"timerslack_ns,omitempty"`
+}
+
+// IntPtr returns a pointer to value, to fill Settings.
+func IntPtr(value int) *int {
+	return &value
+}
+
+// loadMask returns the opt-in fields of Proc that s needs, besides LoadAll.
+func (s Settings) loadMask() (load LoadMask) {
+	if s.AutogroupNice != nil {
+		load |= LoadAutogroup
+	}
+	if s.TimerSlack != nil {
+		load |= LoadTimerSlack
+	}
+	return
+}
+
+// IsEmpty reports whether s changes nothing.
+func (s Settings) IsEmpty() bool {
+	return s.Nice == nil && s.SchedPolicy == nil && s.RTPrio == nil &&
+		s.IOClass == nil && s.IONice == nil && s.OomScoreAdj == nil &&
+		len(s.Affinity) == 0 && s.AutogroupNice == nil && s.TimerSlack == nil
+}
+
+// Merge returns s with the fields set in other replacing its own.
+func (s Settings) Merge(other Settings) Settings {
+	if other.Nice != nil {
+		s.Nice = other.Nice
+	}
+	if other.SchedPolicy != nil {
+		s.SchedPolicy = other.SchedPolicy
+	}
+	if other.RTPrio != nil {
+		s.RTPrio = other.RTPrio
+	}
+	if other.IOClass != nil {
+		s.IOClass = other.IOClass
+	}
+	if other.IONice != nil {
+		s.IONice = other.IONice
+	}
+	if other.OomScoreAdj != nil {
+		s.OomScoreAdj = other.OomScoreAdj
+	}
+	if len(other.Affinity) > 0 {
+		s.Affinity = other.Affinity
+	}
+	if other.AutogroupNice != nil {
+		s.AutogroupNice = other.AutogroupNice
+	}
+	if other.TimerSlack != nil {
+		s.TimerSlack = other.TimerSlack
+	}
+	return s
+}
+
+func (s Settings) String() string {
+	var result []string
+	add := func(name string, value *int) {
+		if value != nil {
+			result = append(result, fmt.Sprintf("%s=%d", name, *value))
+		}
+	}
+	add("nice", s.Nice)
+	if s.SchedPolicy != nil {
+		result = append(result, "sched="+CPUSched[*s.SchedPolicy])
+	}
+	add("rtprio", s.RTPrio)
+	if s.IOClass != nil {
+		result = append(result, "ioclass="+IO.Class[*s.IOClass])
+	}
+	add("ionice", s.IONice)
+	add("oom", s.OomScoreAdj)
+	if len(s.Affinity) > 0 {
+		result = append(result, "affinity="+FormatCPUList(s.Affinity))
+	}
+	add("autogroup", s.AutogroupNice)
+	add("timerslack", s.TimerSlack)
+	return strings.Join(result, ",")
+}
+
+// FormatCPUList returns cpus as a list of ranges, e.g. "0-3,6".
+func FormatCPUList(cpus []int) string {
+	sorted := append([]int{}, cpus...)
+	sort.Ints(sorted)
+	var result []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			result = append(result, strconv.Itoa(sorted[i]))
+		} else {
+			result = append(result, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(result, ",")
+}
+
+// ParseCPUList returns the cpus of a list of ranges, e.g. "0-3,6".
+func ParseCPUList(list string) (cpus []int, err error) {
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		var low, high int
+		if low, err = strconv.Atoi(bounds[0]); err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q", list)
+		}
+		high = low
+		if len(bounds) == 2 {
+			if high, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid cpu list %q", list)
+			}
+		}
+		if low < 0 || high < low {
+			return nil, fmt.Errorf("invalid cpu list %q", list)
+		}
+		for cpu := low; cpu <= high; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return
+}
+
+// Action is a change of a scheduling value of a process, planned or
+// applied.
+type Action struct {
+	Pid       int    `json:"pid"`
+	Comm      string `json:"comm"`
+	StartTime uint64 `json:"starttime"`
+	Rule      string `json:"rule,omitempty"`
+	FieldChange
+	Applied bool  `json:"applied"`
+	Err     error `json:"-"`
+}
+
+func (a Action) String() (result string) {
+	result = fmt.Sprintf("%d (%s) %s", a.Pid, a.Comm, a.FieldChange)
+	if len(a.Rule) > 0 {
+		result += fmt.Sprintf(" [%s]", a.Rule)
+	}
+	switch {
+	case a.Err != nil:
+		result += fmt.Sprintf(": %v", a.Err)
+	case a.Applied:
+		result += ": applied"
+	}
+	return
+}
+
+// schedTarget returns the policy and real-time priority to set, or false
+// when both are unchanged.
+func (s Settings) schedTarget(p *Proc) (policy, rtprio int, changed bool) {
+	policy, rtprio = p.Policy, p.RTPrio
+	if s.SchedPolicy != nil {
+		policy = *s.SchedPolicy
+		if !inSlice(policy, CPU.NeedPriority) {
+			rtprio = 0
+		} else if rtprio == 0 {
+			rtprio = CPU.Low
+		}
+	}
+	if s.RTPrio != nil {
+		rtprio = *s.RTPrio
+	}
+	changed = policy != p.Policy || rtprio != p.RTPrio
+	return
+}
+
+// ioTarget returns the I/O class and priority to set, or false when both
+// are unchanged.
+func (s Settings) ioTarget(p *Proc) (class, data int, changed bool) {
+	class, data = p.IOPrioClass, p.IOPrioData
+	if s.IOClass != nil {
+		class = *s.IOClass
+		if !inSlice(class, IO.NeedPriority) {
+			data = 0
+		} else if class != p.IOPrioClass && s.IONice == nil {
+			data = IO.None
+		}
+	}
+	if s.IONice != nil {
+		data = *s.IONice
+		if class == IOPRIO_CLASS_NONE {
+			class = IOPRIO_CLASS_BE
+		}
+	}
+	// classes without priority ignore data, reported as IOPRIO_NORM for
+	// the class none
+	changed = class != p.IOPrioClass || inSlice(class, IO.NeedPriority) && data != p.IOPrioData
+	return
+}
+
+func inSlice(value int, values []int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// change is a planned action and the function applying it.
+type change struct {
+	FieldChange
+	apply func(h *ProcHandle) error
+}
+
+// taskValues holds the scheduling values of a thread.
+type taskValues struct {
+	Tid         int
+	Policy      int
+	RTPrio      int
+	Nice        int
+	IOPrioClass int
+	IOPrioData  int
+	Affinity    string
+}
+
+// getTaskValues returns the values of the threads of p other than the main
+// one, whose values are those of p. The I/O priority and the affinity,
+// requiring a system call each, are only read when asked.
+func getTaskValues(p *Proc, ioprio, affinity bool) (result []taskValues) {
+	tids, _ := ListTids(p.Pid)
+	for _, tid := range tids {
+		if tid == p.Pid {
+			continue
+		}
+		var stat ProcStat
+		data, err := GetResource(p.Pid, fmt.Sprintf("task/%d/stat", tid))
+		if err != nil || stat.Load(string(data)) != nil {
+			// thread gone
+			continue
+		}
+		t := taskValues{Tid: tid, Policy: stat.Policy, RTPrio: stat.RTPrio, Nice: stat.Nice}
+		if ioprio {
+			if value, err := IOPrio_Get(tid); err == nil {
+				IOPrio_Split(value, &t.IOPrioClass, &t.IOPrioData)
+			}
+		}
+		if affinity {
+			cpus, _ := Sched_GetAffinity(tid)
+			t.Affinity = FormatCPUList(cpus)
+		}
+		result = append(result, t)
+	}
+	return
+}
+
+// changes returns the changes needed for p to match s. The scheduling
+// policy, nice value, I/O priority and affinity are compared for every
+// thread; the old value reported is the one of the main thread, or of the
+// first thread differing.
+func (s Settings) changes(p *Proc) (result []change) {
+	// the threads other than the main one may hold their own values
+	var tasks []taskValues
+	if s.SchedPolicy != nil || s.RTPrio != nil || s.Nice != nil ||
+		s.IOClass != nil || s.IONice != nil || len(s.Affinity) > 0 {
+		tasks = getTaskValues(p, s.IOClass != nil || s.IONice != nil, len(s.Affinity) > 0)
+	}
+	if s.SchedPolicy != nil || s.RTPrio != nil {
+		policy, rtprio, changed := s.schedTarget(p)
+		old := fmt.Sprintf("%s:%d", CPUSched[p.Policy], p.RTPrio)
+		for _, t := range tasks {
+			if !changed && (t.Policy != policy || t.RTPrio != rtprio) {
+				changed, old = true, fmt.Sprintf("%s:%d", CPUSched[t.Policy], t.RTPrio)
+			}
+		}
+		if changed {
+			result = append(result, change{
+				FieldChange{"sched", old, fmt.Sprintf("%s:%d", CPUSched[policy], rtprio)},
+				func(h *ProcHandle) error { return h.SetScheduler(policy, rtprio) },
+			})
+		}
+	}
+	if s.Nice != nil {
+		nice, old := *s.Nice, p.Nice
+		changed := nice != old
+		for _, t := range tasks {
+			if !changed && t.Nice != nice {
+				changed, old = true, t.Nice
+			}
+		}
+		if changed {
+			result = append(result, change{
+				FieldChange{"nice", old, nice},
+				func(h *ProcHandle) error { return h.SetNice(nice) },
+			})
+		}
+	}
+	if s.IOClass != nil || s.IONice != nil {
+		class, data, changed := s.ioTarget(p)
+		old := fmt.Sprintf("%s:%d", IO.Class[p.IOPrioClass], p.IOPrioData)
+		for _, t := range tasks {
+			if !changed && (t.IOPrioClass != class || t.IOPrioData != data) {
+				changed, old = true, fmt.Sprintf("%s:%d", IO.Class[t.IOPrioClass], t.IOPrioData)
+			}
+		}
+		if changed {
+			result = append(result, change{
+				FieldChange{"ioprio", old, fmt.Sprintf("%s:%d", IO.Class[class], data)},
+				func(h *ProcHandle) error { return h.SetIOPrio(class, data) },
+			})
+		}
+	}
+	if s.OomScoreAdj != nil && *s.OomScoreAdj != p.OomScoreAdj {
+		score := *s.OomScoreAdj
+		result = append(result, change{
+			FieldChange{"oom_score_adj", p.OomScoreAdj, score},
+			func(h *ProcHandle) error { return h.SetOomScoreAdj(score) },
+		})
+	}
+	if len(s.Affinity) > 0 {
+		current, _ := Sched_GetAffinity(p.Pid)
+		old, new := FormatCPUList(current), FormatCPUList(s.Affinity)
+		changed := old != new
+		for _, t := range tasks {
+			if !changed && t.Affinity != new {
+				changed, old = true, t.Affinity
+			}
+		}
+		if changed {
+			cpus := s.Affinity
+			result = append(result, change{
+				FieldChange{"affinity", old, new},
+				func(h *ProcHandle) error { return h.SetAffinity(cpus) },
+			})
+		}
+	}
+	// opt-in fields are read on demand when not loaded; when unavailable,
+	// the change fails with the read error
+	if s.AutogroupNice != nil {
+		current, err := p.AutogroupNice, error(nil)
+		if !p.IsLoaded(LoadAutogroup) || p.Autogroup < 0 {
+			if _, current, err = GetAutogroup(p.Pid); err != nil {
+				current = -1
+			}
+		}
+		if nice := *s.AutogroupNice; err != nil || nice != current {
+			result = append(result, change{
+				FieldChange{"autogroup_nice", current, nice},
+				func(h *ProcHandle) error {
+					if err != nil {
+						return err
+					}
+					return h.SetAutogroupNice(nice)
+				},
+			})
+		}
+	}
+	if s.TimerSlack != nil {
+		current, err := p.TimerSlack, error(nil)
+		if !p.IsLoaded(LoadTimerSlack) || p.TimerSlack < 0 {
+			if current, err = GetTimerSlack(p.Pid); err != nil {
+				current = -1
+			}
+		}
+		if ns := *s.TimerSlack; err != nil || ns != current {
+			result = append(result, change{
+				FieldChange{"timerslack_ns", current, ns},
+				func(h *ProcHandle) error {
+					if err != nil {
+						return err
+					}
+					return h.SetTimerSlack(ns)
+				},
+			})
+		}
+	}
+	return
+}
+
+func newAction(p *Proc, c FieldChange) Action {
+	return Action{Pid: p.Pid, Comm: p.Comm, StartTime: p.StartTime, FieldChange: c}
+}
+
+// Plan returns the actions needed for p to match s, without applying them.
+func (s Settings) Plan(p *Proc) (actions []Action) {
+	for _, c := range s.changes(p) {
+		actions = append(actions, newAction(p, c.FieldChange))
+	}
+	return
+}
+
+// Apply applies the actions needed for p to match s, through a ProcHandle
+// so that a process reusing the pid of p is never modified. Per-thread
+// values are set for every thread of p.
+func (s Settings) Apply(p *Proc) (actions []Action) {
+	changes := s.changes(p)
+	if len(changes) == 0 {
+		return
+	}
+	h, err := p.Handle()
+	if err == nil {
+		defer h.Close()
+	}
+	for _, c := range changes {
+		action := newAction(p, c.FieldChange)
+		if action.Err = err; err == nil {
+			action.Err = c.apply(h)
+			action.Applied = action.Err == nil
+		}
+		actions = append(actions, action)
+	}
+	return
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,103 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func readSelf(t *testing.T) *Proc {
+	t.Helper()
+	stat, err := ReadStat(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProcFromStat(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// lockedThread returns the id of a thread other than the main one, locked
+// until the end of the test so that its values can be changed apart.
+func lockedThread(t *testing.T) int {
+	t.Helper()
+	ready, done := make(chan int), make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	lockThread := func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		ready <- unix.Gettid()
+		<-done
+	}
+	go lockThread()
+	tid := <-ready
+	if tid == os.Getpid() {
+		// at most one goroutine is locked to the main thread
+		go lockThread()
+		tid = <-ready
+	}
+	return tid
+}
+
+func TestApplyEveryThread(t *testing.T) {
+	p := readSelf(t)
+	if p.Nice >= 18 {
+		t.Skipf("nice %d too high", p.Nice)
+	}
+	nice := p.Nice + 1
+	// a thread with its own nice value, the main thread keeping p.Nice
+	tid := lockedThread(t)
+	if err := SetNice(tid, nice); err != nil {
+		t.Fatal(err)
+	}
+
+	s := Settings{Nice: IntPtr(nice)}
+	actions := s.Plan(p)
+	if len(actions) != 1 || actions[0].Field != "nice" || actions[0].Old != p.Nice {
+		t.Fatalf("Plan returned %v", actions)
+	}
+	for _, a := range s.Apply(p) {
+		if a.Err != nil {
+			t.Fatal(a.Err)
+		}
+	}
+	for _, task := range getTaskValues(p, false, false) {
+		if task.Nice != nice {
+			t.Errorf("thread %d has nice %d, want %d", task.Tid, task.Nice, nice)
+		}
+	}
+	if actions := s.Plan(readSelf(t)); len(actions) > 0 {
+		t.Errorf("Plan after Apply returned %v", actions)
+	}
+
+	// only the locked thread differs now
+	if err := SetNice(tid, nice+1); err != nil {
+		t.Skip(err)
+	}
+	actions = s.Plan(readSelf(t))
+	if len(actions) != 1 || actions[0].Old != nice+1 {
+		t.Errorf("Plan returned %v, want the value of thread %d", actions, tid)
+	}
+}
@@ -0,0 +1,205 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CPUTotals holds the aggregated "cpu" line of /proc/stat, in ticks.
+type CPUTotals struct {
+	User    uint64 `json:"user"`
+	Nice    uint64 `json:"nice"`
+	System  uint64 `json:"system"`
+	Idle    uint64 `json:"idle"`
+	IOWait  uint64 `json:"iowait"`
+	IRQ     uint64 `json:"irq"`
+	SoftIRQ uint64 `json:"softirq"`
+	Steal   uint64 `json:"steal"`
+	NumCPU  int    `json:"num_cpu"`
+}
+
+// Total returns the sum of all the CPU time counters.
+func (t CPUTotals) Total() uint64 {
+	return t.User + t.Nice + t.System + t.Idle + t.IOWait + t.IRQ +
+		t.SoftIRQ + t.Steal
+}
+
+// GetCPUTotals reads the system CPU time counters from /proc/stat.
+func GetCPUTotals() (totals CPUTotals, err error) {
+	data, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "cpu "):
+			_, err = fmt.Sscan(
+				strings.TrimPrefix(line, "cpu "),
+				&totals.User,
+				&totals.Nice,
+				&totals.System,
+				&totals.Idle,
+				&totals.IOWait,
+				&totals.IRQ,
+				&totals.SoftIRQ,
+				&totals.Steal,
+			)
+			if err != nil {
+				return
+			}
+		case strings.HasPrefix(line, "cpu"):
+			totals.NumCPU++
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// CtxSwitches holds the context switch counters of a process.
+type CtxSwitches struct {
+	Voluntary    uint64 `json:"voluntary_ctxt_switches"`
+	NonVoluntary uint64 `json:"nonvoluntary_ctxt_switches"`
+}
+
+// GetCtxSwitches reads the context switch counters from /proc/[pid]/status.
+func GetCtxSwitches(pid int) (switches CtxSwitches, err error) {
+	data, err := GetResource(pid, "status")
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "voluntary_ctxt_switches:":
+			switches.Voluntary, err = strconv.ParseUint(fields[1], 10, 64)
+		case "nonvoluntary_ctxt_switches:":
+			switches.NonVoluntary, err = strconv.ParseUint(fields[1], 10, 64)
+		}
+		if err != nil {
+			return
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// Snapshot holds the state of filtered processes at a given time.
+type Snapshot struct {
+	Time     time.Time           `json:"time"`
+	CPU      CPUTotals           `json:"cpu"`
+	Procs    []*Proc             `json:"procs"`
+	Switches map[int]CtxSwitches `json:"switches"`
+}
+
+// TakeSnapshot returns a Snapshot of the processes selected by filter.
+func TakeSnapshot(filter Filterer, options ...ScanOption) (s *Snapshot, err error) {
+	s = &Snapshot{Switches: make(map[int]CtxSwitches)}
+	if s.CPU, err = GetCPUTotals(); err != nil {
+		return nil, err
+	}
+	s.Time = time.Now()
+	s.Procs = FilteredProcs(filter, options...)
+	for _, p := range s.Procs {
+		if switches, err := GetCtxSwitches(p.Pid); err == nil {
+			s.Switches[p.Pid] = switches
+		}
+	}
+	return
+}
+
+// ProcDelta holds the activity of a process between two snapshots. Rates
+// are given per second.
+type ProcDelta struct {
+	Proc                *Proc         `json:"proc"`
+	Elapsed             time.Duration `json:"elapsed"`
+	CPUPercent          float64       `json:"cpu_percent"`
+	MinFltRate          float64       `json:"minflt_rate"`
+	MajFltRate          float64       `json:"majflt_rate"`
+	VoluntaryCtxRate    float64       `json:"voluntary_ctxt_rate"`
+	NonVoluntaryCtxRate float64       `json:"nonvoluntary_ctxt_rate"`
+}
+
+// sameProcess reports whether a and b are the same process, checking start
+// time so that a reused Pid is not mistaken for the former process.
+func sameProcess(a, b *Proc) bool {
+	return a.Pid == b.Pid && a.StartTime == b.StartTime
+}
+
+func rate(prev, cur uint64, seconds float64) float64 {
+	if cur < prev || seconds <= 0 {
+		return 0
+	}
+	return float64(cur-prev) / seconds
+}
+
+// Diff returns the activity of the processes found in both prev and cur.
+// CPU percentage is relative to a single CPU, like top does, so that a
+// multithreaded process may exceed 100.
+func Diff(prev, cur *Snapshot) (result []ProcDelta) {
+	elapsed := cur.Time.Sub(prev.Time)
+	seconds := elapsed.Seconds()
+	// ticks elapsed on a single CPU
+	var ticks float64
+	if total := cur.CPU.Total(); total > prev.CPU.Total() && cur.CPU.NumCPU > 0 {
+		ticks = float64(total-prev.CPU.Total()) / float64(cur.CPU.NumCPU)
+	} else {
+		ticks = seconds * float64(userHZ)
+	}
+	previous := make(map[int]*Proc, len(prev.Procs))
+	for _, p := range prev.Procs {
+		previous[p.Pid] = p
+	}
+	for _, p := range cur.Procs {
+		old, found := previous[p.Pid]
+		if !found || !sameProcess(old, p) {
+			continue
+		}
+		delta := ProcDelta{Proc: p, Elapsed: elapsed}
+		if used := p.UTime + p.STime; ticks > 0 && used >= old.UTime+old.STime {
+			delta.CPUPercent = float64(used-old.UTime-old.STime) / ticks * 100
+		}
+		delta.MinFltRate = rate(uint64(old.MinFlt), uint64(p.MinFlt), seconds)
+		delta.MajFltRate = rate(uint64(old.MajFlt), uint64(p.MajFlt), seconds)
+		before, okBefore := prev.Switches[p.Pid]
+		after, okAfter := cur.Switches[p.Pid]
+		if okBefore && okAfter {
+			delta.VoluntaryCtxRate = rate(before.Voluntary, after.Voluntary, seconds)
+			delta.NonVoluntaryCtxRate = rate(
+				before.NonVoluntary, after.NonVoluntary, seconds,
+			)
+		}
+		result = append(result, delta)
+	}
+	return
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
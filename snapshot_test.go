@@ -0,0 +1,137 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// snapshotProc returns a Proc holding the counters compared by Diff.
+func snapshotProc(pid int, starttime uint64, utime, stime, minflt uint) *Proc {
+	return &Proc{ProcStat: ProcStat{
+		Pid: pid, StartTime: starttime, UTime: utime, STime: stime, MinFlt: minflt,
+	}}
+}
+
+func TestDiff(t *testing.T) {
+	start := time.Unix(1000, 0)
+	hz := uint(userHZ)
+	for _, tt := range []struct {
+		name      string
+		prev      Snapshot
+		cur       Snapshot
+		pids      []int
+		cpu       float64
+		minflt    float64
+		voluntary float64
+	}{
+		{
+			name: "reused pid",
+			prev: Snapshot{Time: start, Procs: []*Proc{snapshotProc(10, 100, 0, 0, 0)}},
+			cur: Snapshot{
+				Time:  start.Add(time.Second),
+				Procs: []*Proc{snapshotProc(10, 200, 50, 0, 10)},
+			},
+		},
+		{
+			// 400 ticks over 4 CPUs, 50 used by the process
+			name: "cpu totals",
+			prev: Snapshot{
+				Time:  start,
+				CPU:   CPUTotals{User: 600, Idle: 400, NumCPU: 4},
+				Procs: []*Proc{snapshotProc(10, 100, 10, 10, 100)},
+			},
+			cur: Snapshot{
+				Time:  start.Add(time.Second),
+				CPU:   CPUTotals{User: 800, Idle: 600, NumCPU: 4},
+				Procs: []*Proc{snapshotProc(10, 100, 40, 30, 300)},
+			},
+			pids:   []int{10},
+			cpu:    50,
+			minflt: 200,
+		},
+		{
+			// no CPU totals, seconds*userHZ ticks over 2 seconds
+			name:   "elapsed time",
+			prev:   Snapshot{Time: start, Procs: []*Proc{snapshotProc(10, 100, 0, 0, 0)}},
+			cur:    Snapshot{Time: start.Add(2 * time.Second), Procs: []*Proc{snapshotProc(10, 100, hz, 0, 0)}},
+			pids:   []int{10},
+			cpu:    50,
+			minflt: 0,
+		},
+		{
+			name: "counter wrap",
+			prev: Snapshot{Time: start, Procs: []*Proc{snapshotProc(10, 100, 100, 100, 500)}},
+			cur:  Snapshot{Time: start.Add(time.Second), Procs: []*Proc{snapshotProc(10, 100, 10, 10, 5)}},
+			pids: []int{10},
+		},
+		{
+			name: "context switches",
+			prev: Snapshot{
+				Time:     start,
+				Procs:    []*Proc{snapshotProc(10, 100, 0, 0, 0), snapshotProc(11, 100, 0, 0, 0)},
+				Switches: map[int]CtxSwitches{10: {Voluntary: 10}},
+			},
+			cur: Snapshot{
+				Time:     start.Add(time.Second),
+				Procs:    []*Proc{snapshotProc(10, 100, 0, 0, 0), snapshotProc(11, 100, 0, 0, 0)},
+				Switches: map[int]CtxSwitches{10: {Voluntary: 30}, 11: {Voluntary: 30}},
+			},
+			pids:      []int{10, 11},
+			voluntary: 20,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			deltas := Diff(&tt.prev, &tt.cur)
+			if len(deltas) != len(tt.pids) {
+				t.Fatalf("got %d deltas, want %d", len(deltas), len(tt.pids))
+			}
+			for i, d := range deltas {
+				if d.Proc.Pid != tt.pids[i] {
+					t.Errorf("got pid %d, want %d", d.Proc.Pid, tt.pids[i])
+				}
+			}
+			if len(deltas) == 0 {
+				return
+			}
+			d := deltas[0]
+			for _, value := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"cpu percent", d.CPUPercent, tt.cpu},
+				{"minflt rate", d.MinFltRate, tt.minflt},
+				{"voluntary rate", d.VoluntaryCtxRate, tt.voluntary},
+			} {
+				if math.Abs(value.got-value.want) > 1e-9 {
+					t.Errorf("got %s %v, want %v", value.name, value.got, value.want)
+				}
+			}
+			// rates need the counters of both snapshots
+			for _, d := range deltas[1:] {
+				if d.VoluntaryCtxRate != 0 {
+					t.Errorf("pid %d: got voluntary rate %v without former counters",
+						d.Proc.Pid, d.VoluntaryCtxRate)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// compareFunc returns a negative value when a sorts before b, a positive
+// value when a sorts after b, and zero otherwise.
+type compareFunc func(a, b *Proc) int
+
+var (
+	// sortFields maps json names and lowercased field names of Proc and
+	// ProcStat to their index in Proc.
+	sortFields map[string][]int
+	// sortDerived holds keys computed from Proc methods.
+	sortDerived map[string]compareFunc
+)
+
+func init() {
+	sortFields = make(map[string][]int)
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			path := append(append([]int{}, index...), i)
+			if f.Anonymous {
+				walk(f.Type, path)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			sortFields[strings.ToLower(f.Name)] = path
+			if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+				sortFields[tag] = path
+			}
+		}
+	}
+	walk(reflect.TypeOf(Proc{}), nil)
+
+	sortDerived = map[string]compareFunc{
+		"cputime": func(a, b *Proc) int {
+			return compareFloat(float64(a.CPUTime()), float64(b.CPUTime()))
+		},
+		"age": func(a, b *Proc) int {
+			return compareFloat(float64(a.Age()), float64(b.Age()))
+		},
+		"cpuusage": func(a, b *Proc) int {
+			return compareFloat(a.CPUUsage(), b.CPUUsage())
+		},
+		"username": func(a, b *Proc) int {
+			return strings.Compare(a.Username(), b.Username())
+		},
+		"groupname": func(a, b *Proc) int {
+			return strings.Compare(a.Groupname(), b.Groupname())
+		},
+		"sched": func(a, b *Proc) int {
+			return strings.Compare(a.Sched(), b.Sched())
+		},
+		"ioclass": func(a, b *Proc) int {
+			return strings.Compare(a.IOClass(), b.IOClass())
+		},
+	}
+	sortDerived["user"] = sortDerived["username"]
+	sortDerived["group"] = sortDerived["groupname"]
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareValue(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, y := a.Int(), b.Int()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		x, y := a.Uint(), b.Uint()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	case reflect.Float32, reflect.Float64:
+		return compareFloat(a.Float(), b.Float())
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < a.Len() && i < b.Len(); i++ {
+			if c := compareValue(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+		return a.Len() - b.Len()
+	}
+	return 0
+}
+
+// sortKey returns the comparison function for a single key such as "-rss",
+// "+nice" or "comm". A leading minus sign reverses the order.
+func sortKey(key string) (compare compareFunc, err error) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	descending := strings.HasPrefix(key, "-")
+	name := strings.TrimLeft(key, "+-")
+	if fn, found := sortDerived[name]; found {
+		compare = fn
+	} else if index, found := sortFields[name]; found {
+		compare = func(a, b *Proc) int {
+			return compareValue(
+				reflect.ValueOf(a).Elem().FieldByIndex(index),
+				reflect.ValueOf(b).Elem().FieldByIndex(index),
+			)
+		}
+	} else {
+		return nil, fmt.Errorf("unknown sort key: %q", key)
+	}
+	if descending {
+		ascending := compare
+		compare = func(a, b *Proc) int { return ascending(b, a) }
+	}
+	return
+}
+
+// ProcSorter implements sort.Interface for []*Proc based on a list of keys
+type ProcSorter struct {
+	procs []*Proc
+	keys  []compareFunc
+}
+
+// NewProcSorter returns a ProcSorter for procs. Each key is a comma
+// separated list of field names, optionally prefixed with "+" for ascending
+// or "-" for descending order. Ties are broken by Pid.
+func NewProcSorter(procs []*Proc, keys ...string) (s *ProcSorter, err error) {
+	s = &ProcSorter{procs: procs}
+	for _, spec := range keys {
+		for _, key := range strings.Split(spec, ",") {
+			if len(strings.TrimSpace(key)) == 0 {
+				continue
+			}
+			var compare compareFunc
+			if compare, err = sortKey(key); err != nil {
+				return nil, err
+			}
+			s.keys = append(s.keys, compare)
+		}
+	}
+	return
+}
+
+func (s *ProcSorter) Len() int      { return len(s.procs) }
+func (s *ProcSorter) Swap(i, j int) { s.procs[i], s.procs[j] = s.procs[j], s.procs[i] }
+func (s *ProcSorter) Less(i, j int) bool {
+	for _, compare := range s.keys {
+		if c := compare(s.procs[i], s.procs[j]); c != 0 {
+			return c < 0
+		}
+	}
+	return s.procs[i].Pid < s.procs[j].Pid
+}
+
+// SortProcs sorts procs in place according to keys, e.g. "-rss,+nice,comm".
+func SortProcs(procs []*Proc, keys ...string) error {
+	s, err := NewProcSorter(procs, keys...)
+	if err != nil {
+		return err
+	}
+	sort.Stable(s)
+	return nil
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,43 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import "testing"
+
+func TestScanProcsInvalidSortKey(t *testing.T) {
+	if _, err := ScanProcs(GetFilterer("all"), SortBy("-rss,nosuchkey")); err == nil {
+		t.Fatal("ScanProcs accepted an invalid sort key")
+	}
+	procs, err := ScanProcs(GetFilterer("all"), SortBy("-pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(procs); i++ {
+		if procs[i-1].Pid < procs[i].Pid {
+			t.Fatalf("not sorted by -pid: %d before %d", procs[i-1].Pid, procs[i].Pid)
+		}
+	}
+	// FilteredProcs falls back to Pid order
+	procs = FilteredProcs(GetFilterer("all"), SortBy("nosuchkey"))
+	for i := 1; i < len(procs); i++ {
+		if procs[i-1].Pid > procs[i].Pid {
+			t.Fatalf("not sorted by pid: %d before %d", procs[i-1].Pid, procs[i].Pid)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseSpec parses "class", "class:priority" or "number:class:priority", as
+// rendered by CPUSchedInfo and IOSchedInfo. It reports whether the priority
+// was given; otherwise the default priority of the class is returned.
+// Classes without priority accept 0 or the neutral priority, as the kernel
+// reports IOPRIO_NORM for the I/O class none, and return 0.
+func (sp SchedulingPolicy) parseSpec(kind, spec string) (class, priority int, explicit bool, err error) {
+	fail := func(format string, a ...interface{}) (int, int, bool, error) {
+		return -1, 0, false, fmt.Errorf("invalid %s scheduling %q: %s", kind, spec, fmt.Sprintf(format, a...))
+	}
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	var found bool
+	switch len(parts) {
+	case 1, 2:
+		if class, found = sp.Lookup(parts[0]); !found {
+			return fail("unknown class %q", parts[0])
+		}
+	case 3:
+		if class, found = sp.Lookup(parts[1]); !found {
+			return fail("unknown class %q", parts[1])
+		}
+		if number, err := strconv.Atoi(parts[0]); err != nil || number != class {
+			return fail("class %q is not %s", parts[0], sp.Class[class])
+		}
+	default:
+		return fail("too many fields")
+	}
+	needPriority := inSlice(class, sp.NeedPriority)
+	if explicit = len(parts) > 1; !explicit {
+		if needPriority {
+			priority = sp.None
+			if priority == 0 {
+				// no neutral priority, use the lowest one
+				priority = sp.Low
+			}
+		}
+		return class, priority, false, nil
+	}
+	if priority, err = strconv.Atoi(parts[len(parts)-1]); err != nil {
+		return fail("priority %q is not a number", parts[len(parts)-1])
+	}
+	low, high := sp.Low, sp.High
+	if low > high {
+		low, high = high, low
+	}
+	switch {
+	case needPriority && (priority < low || priority > high):
+		return fail("priority %d out of range [%d, %d]", priority, low, high)
+	case !needPriority && priority != 0 && priority != sp.None:
+		return fail("class %s takes no priority", sp.Class[class])
+	case !needPriority:
+		priority = 0
+	}
+	return class, priority, true, nil
+}
+
+// ParseCPUSched returns the CPU scheduling policy and real-time priority
+// of spec, e.g. "rr:50", "batch" or "2:SCHED_RR:50". Classes are given by
+// number or name, with or without "SCHED_" prefix.
+func ParseCPUSched(spec string) (policy, rtprio int, err error) {
+	policy, rtprio, _, err = CPU.parseSpec("cpu", spec)
+	return
+}
+
+// ParseIOSched returns the I/O scheduling class and priority of spec, e.g.
+// "best-effort:4", "idle" or "2:best-effort:4".
+func ParseIOSched(spec string) (class, data int, err error) {
+	class, data, _, err = IO.parseSpec("io", spec)
+	return
+}
+
+// ParseSchedSpec returns the Settings of a comma separated list of
+// key=value, e.g. "nice=5,io=idle,sched=batch,oom=300". It accepts the
+// output of Settings.String. Keys are:
+//
+//	nice, rtprio, ionice, autogroup, timerslack
+//	sched          CPU scheduling, as parsed by ParseCPUSched, but deadline
+//	io, ioclass    I/O scheduling, as parsed by ParseIOSched
+//	oom            OOM killer score adjustment
+//	affinity       list of cpus, e.g. "0-3,6"
+func ParseSchedSpec(spec string) (s Settings, err error) {
+	// values of affinity may hold commas
+	var pairs []string
+	for _, part := range strings.Split(spec, ",") {
+		if part = strings.TrimSpace(part); len(part) == 0 {
+			continue
+		}
+		if !strings.Contains(part, "=") && len(pairs) > 0 {
+			pairs[len(pairs)-1] += "," + part
+			continue
+		}
+		pairs = append(pairs, part)
+	}
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return s, fmt.Errorf("invalid scheduling spec %q: missing value for %q", spec, pair)
+		}
+		key, value := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+		if err = s.set(key, value); err != nil {
+			return s, fmt.Errorf("invalid scheduling spec %q: %w", spec, err)
+		}
+	}
+	if err = s.checkRTPrio(); err != nil {
+		return s, fmt.Errorf("invalid scheduling spec %q: %w", spec, err)
+	}
+	return
+}
+
+// checkRTPrio reports a real-time priority not matching the policy, rtprio
+// 0 being only valid with a policy taking no priority.
+func (s Settings) checkRTPrio() error {
+	if s.RTPrio == nil {
+		return nil
+	}
+	rtprio := *s.RTPrio
+	if s.SchedPolicy != nil && !inSlice(*s.SchedPolicy, CPU.NeedPriority) {
+		if rtprio != 0 {
+			return fmt.Errorf("policy %s takes no rtprio", CPUSched[*s.SchedPolicy])
+		}
+		return nil
+	}
+	return checkRange("rtprio", &rtprio, CPU.Low, CPU.High)
+}
+
+// set parses value into the field named key.
+func (s *Settings) set(key, value string) (err error) {
+	integer := func(name string, low, high int) (*int, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q is not a number", name, value)
+		}
+		return &n, checkRange(name, &n, low, high)
+	}
+	switch key {
+	case "nice":
+		s.Nice, err = integer(key, -20, 19)
+	case "autogroup", "autogroup_nice":
+		s.AutogroupNice, err = integer("autogroup", -20, 19)
+	case "rtprio":
+		// checked against the policy once parsed
+		s.RTPrio, err = integer(key, 0, CPU.High)
+	case "ionice":
+		s.IONice, err = integer(key, IO.High, IO.Low)
+	case "oom", "oom_score_adj":
+		s.OomScoreAdj, err = integer("oom", -1000, 1000)
+	case "timerslack", "timerslack_ns":
+		s.TimerSlack, err = integer("timerslack", 0, int(^uint(0)>>1))
+	case "sched":
+		policy, rtprio, explicit, err := CPU.parseSpec("cpu", value)
+		if err != nil {
+			return err
+		}
+		if policy == SCHED_DEADLINE {
+			// requires sched_setattr and its runtime parameters
+			return fmt.Errorf("policy %s not supported", CPUSched[policy])
+		}
+		s.SchedPolicy = &policy
+		if explicit && inSlice(policy, CPU.NeedPriority) {
+			s.RTPrio = &rtprio
+		}
+	case "io", "ioclass":
+		class, data, explicit, err := IO.parseSpec("io", value)
+		if err != nil {
+			return err
+		}
+		s.IOClass = &class
+		if explicit && inSlice(class, IO.NeedPriority) {
+			s.IONice = &data
+		}
+	case "affinity":
+		if s.Affinity, err = ParseCPUList(value); err == nil && len(s.Affinity) == 0 {
+			err = fmt.Errorf("empty affinity")
+		}
+	default:
+		err = fmt.Errorf("unknown key %q", key)
+	}
+	return
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,87 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSchedSpec(t *testing.T) {
+	for _, tt := range []struct {
+		spec  string
+		want  string
+		fails bool
+	}{
+		{"nice=5,io=idle,sched=batch,oom=300", "nice=5,sched=batch,ioclass=idle,oom=300", false},
+		{"sched=rr:50", "sched=rr,rtprio=50", false},
+		{"sched=2:SCHED_RR:50,affinity=0-3,6", "sched=rr,rtprio=50,affinity=0-3,6", false},
+		// the priority of classes taking none is dropped
+		{"sched=other:0", "sched=other", false},
+		{"sched=other,rtprio=0", "sched=other,rtprio=0", false},
+		{"rtprio=0,sched=idle", "sched=idle,rtprio=0", false},
+		{"io=0:none:4", "ioclass=none", false},
+		{"io=none:0", "ioclass=none", false},
+		{"io=best-effort:4", "ioclass=best-effort,ionice=4", false},
+		{"sched=other:5", "", true},
+		{"sched=other,rtprio=5", "", true},
+		{"sched=rr,rtprio=0", "", true},
+		{"rtprio=0", "", true},
+		{"io=none:3", "", true},
+		{"sched=deadline", "", true},
+		{"sched=6:deadline:0", "", true},
+		{"nice=20", "", true},
+		{"colour=blue", "", true},
+	} {
+		s, err := ParseSchedSpec(tt.spec)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("ParseSchedSpec(%q) returned %v", tt.spec, s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSchedSpec(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.String(); got != tt.want {
+			t.Errorf("ParseSchedSpec(%q) returned %q, want %q", tt.spec, got, tt.want)
+		}
+		// the output of String parses back to the same Settings
+		if again, err := ParseSchedSpec(s.String()); err != nil {
+			t.Errorf("ParseSchedSpec(%q): %v", s.String(), err)
+		} else if !reflect.DeepEqual(again, s) {
+			t.Errorf("ParseSchedSpec(%q) returned %v, want %v", s.String(), again, s)
+		}
+	}
+}
+
+func TestUndoSettingsRoundTrip(t *testing.T) {
+	p := &Proc{ProcStat: ProcStat{Policy: SCHED_OTHER}, IOPrioClass: IOPRIO_CLASS_NONE, IOPrioData: 4}
+	undo := undoSettings(p, []Action{
+		{FieldChange: FieldChange{Field: "sched"}, Applied: true},
+		{FieldChange: FieldChange{Field: "ioprio"}, Applied: true},
+	})
+	if _, err := ParseSchedSpec(undo.String()); err != nil {
+		t.Errorf("undo %q: %v", undo.String(), err)
+	}
+	if _, _, changed := undo.ioTarget(p); changed {
+		t.Errorf("undo %q changes the I/O class none", undo.String())
+	}
+}
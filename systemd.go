@@ -0,0 +1,119 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// unitSuffixes lists the unit types that may own processes.
+var unitSuffixes = []string{".service", ".scope", ".socket", ".mount", ".swap"}
+
+func isUnit(name string) bool {
+	for _, suffix := range unitSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SystemdUnit describes the placement of a process in the systemd cgroup
+// tree.
+type SystemdUnit struct {
+	// Unit is the unit owning the process, e.g. "nginx.service".
+	Unit string `json:"unit"`
+	// Slices holds the ancestors of Unit, e.g. user.slice, user-1000.slice,
+	// user@1000.service and app.slice.
+	Slices []string `json:"slices"`
+	// Session is the login session id, when inside a session scope.
+	Session string `json:"session"`
+	// UserManager is the user manager unit, e.g. "user@1000.service", when
+	// the process runs under a systemd user instance.
+	UserManager string `json:"user_manager"`
+}
+
+// InUserManager reports whether the process runs under a user manager.
+func (u SystemdUnit) InUserManager() bool {
+	return len(u.UserManager) > 0
+}
+
+// Slice returns the innermost slice, or an empty string.
+func (u SystemdUnit) Slice() string {
+	for i := len(u.Slices) - 1; i >= 0; i-- {
+		if strings.HasSuffix(u.Slices[i], ".slice") {
+			return u.Slices[i]
+		}
+	}
+	return ""
+}
+
+func (u SystemdUnit) String() string {
+	return fmt.Sprintf(
+		"{Unit: %s, Slices: %s, Session: %s, UserManager: %s}",
+		u.Unit, strings.Join(u.Slices, " -> "), u.Session, u.UserManager,
+	)
+}
+
+// ParseSystemdUnit returns the SystemdUnit for a cgroup path such as
+// "/user.slice/user-1000.slice/user@1000.service/app.slice/foo.scope".
+func ParseSystemdUnit(cgroup string) (u SystemdUnit) {
+	var segments []string
+	for _, segment := range strings.Split(path.Clean(cgroup), "/") {
+		if len(segment) > 0 {
+			segments = append(segments, segment)
+		}
+	}
+	// the owning unit is the innermost one, nested cgroups being possibly
+	// created below it by a delegated service
+	owner := -1
+	for i, segment := range segments {
+		if isUnit(segment) {
+			owner = i
+		}
+	}
+	if owner >= 0 {
+		u.Unit = segments[owner]
+		u.Slices = segments[:owner]
+	} else {
+		u.Slices = segments
+	}
+	for i, segment := range segments {
+		if owner >= 0 && i > owner {
+			break
+		}
+		switch {
+		case strings.HasPrefix(segment, "user@") && strings.HasSuffix(segment, ".service"):
+			u.UserManager = segment
+		case strings.HasPrefix(segment, "session-") && strings.HasSuffix(segment, ".scope"):
+			u.Session = strings.TrimSuffix(strings.TrimPrefix(segment, "session-"), ".scope")
+		}
+	}
+	return
+}
+
+// SystemdUnit returns the placement of the process in the systemd cgroup
+// tree, derived from its cgroup path.
+func (p *Proc) SystemdUnit() SystemdUnit {
+	return ParseSystemdUnit(p.CgroupPath())
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
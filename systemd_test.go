@@ -0,0 +1,87 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSystemdUnit(t *testing.T) {
+	for _, tt := range []struct {
+		cgroup string
+		want   SystemdUnit
+		slice  string
+	}{
+		{
+			"/system.slice/nginx.service",
+			SystemdUnit{Unit: "nginx.service", Slices: []string{"system.slice"}},
+			"system.slice",
+		},
+		{
+			"/user.slice/user-1000.slice/session-3.scope",
+			SystemdUnit{
+				Unit:    "session-3.scope",
+				Slices:  []string{"user.slice", "user-1000.slice"},
+				Session: "3",
+			},
+			"user-1000.slice",
+		},
+		{
+			"/user.slice/user-1000.slice/user@1000.service/app.slice/app-firefox-1234.scope",
+			SystemdUnit{
+				Unit:        "app-firefox-1234.scope",
+				Slices:      []string{"user.slice", "user-1000.slice", "user@1000.service", "app.slice"},
+				UserManager: "user@1000.service",
+			},
+			"app.slice",
+		},
+		{
+			"/user.slice/user-1000.slice/user@1000.service/init.scope",
+			SystemdUnit{
+				Unit:        "init.scope",
+				Slices:      []string{"user.slice", "user-1000.slice", "user@1000.service"},
+				UserManager: "user@1000.service",
+			},
+			"user-1000.slice",
+		},
+		{
+			// nested cgroups below a delegated service
+			"/system.slice/docker.service/payload/inner",
+			SystemdUnit{Unit: "docker.service", Slices: []string{"system.slice"}},
+			"system.slice",
+		},
+		{"/", SystemdUnit{}, ""},
+		{"/machine.slice", SystemdUnit{Slices: []string{"machine.slice"}}, "machine.slice"},
+	} {
+		got := ParseSystemdUnit(tt.cgroup)
+		if len(got.Slices) == 0 {
+			got.Slices = nil
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSystemdUnit(%q) = %v, want %v", tt.cgroup, got, tt.want)
+		}
+		if slice := got.Slice(); slice != tt.slice {
+			t.Errorf("ParseSystemdUnit(%q).Slice() = %q, want %q", tt.cgroup, slice, tt.slice)
+		}
+		if got.InUserManager() != (len(tt.want.UserManager) > 0) {
+			t.Errorf("ParseSystemdUnit(%q).InUserManager() = %v", tt.cgroup, got.InUserManager())
+		}
+	}
+}
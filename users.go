@@ -0,0 +1,259 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"bufio"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UserResolver resolves user and group ids into names.
+type UserResolver interface {
+	LookupUser(uid int) (*user.User, error)
+	LookupGroup(gid int) (*user.Group, error)
+}
+
+var (
+	resolverMutex sync.RWMutex
+	// userResolver is used by GetUser and GetGroup.
+	userResolver UserResolver = NewCachedResolver(OSResolver{}, 5*time.Minute)
+)
+
+// SetUserResolver sets the UserResolver used when loading processes.
+func SetUserResolver(r UserResolver) {
+	resolverMutex.Lock()
+	defer resolverMutex.Unlock()
+	userResolver = r
+}
+
+func getUserResolver() UserResolver {
+	resolverMutex.RLock()
+	defer resolverMutex.RUnlock()
+	return userResolver
+}
+
+// OSResolver resolves ids with the os/user package, which may query NSS
+// when built with cgo.
+type OSResolver struct{}
+
+func (OSResolver) LookupUser(uid int) (*user.User, error) {
+	return user.LookupId(strconv.Itoa(uid))
+}
+
+func (OSResolver) LookupGroup(gid int) (*user.Group, error) {
+	return user.LookupGroupId(strconv.Itoa(gid))
+}
+
+// FileResolver resolves ids from passwd and group files, reloading them
+// when they are modified.
+type FileResolver struct {
+	PasswdPath string
+	GroupPath  string
+	mutex      sync.Mutex
+	users      map[int]*user.User
+	groups     map[int]*user.Group
+	passwdTime time.Time
+	groupTime  time.Time
+}
+
+// NewFileResolver returns a FileResolver reading /etc/passwd and
+// /etc/group.
+func NewFileResolver() *FileResolver {
+	return &FileResolver{PasswdPath: "/etc/passwd", GroupPath: "/etc/group"}
+}
+
+// readColonFile calls fn with the fields of each line of path, when path
+// was modified after *mtime.
+func readColonFile(path string, mtime *time.Time, fn func(fields []string)) (err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if !info.ModTime().After(*mtime) {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(strings.Split(line, ":"))
+	}
+	if err = scanner.Err(); err == nil {
+		*mtime = info.ModTime()
+	}
+	return
+}
+
+func (r *FileResolver) loadUsers() error {
+	users := make(map[int]*user.User)
+	err := readColonFile(r.PasswdPath, &r.passwdTime, func(fields []string) {
+		// name:password:uid:gid:gecos:home:shell
+		if len(fields) < 7 {
+			return
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		if _, found := users[uid]; !found {
+			users[uid] = &user.User{
+				Uid:      fields[2],
+				Gid:      fields[3],
+				Username: fields[0],
+				Name:     strings.Split(fields[4], ",")[0],
+				HomeDir:  fields[5],
+			}
+		}
+	})
+	if err == nil && len(users) > 0 {
+		r.users = users
+	}
+	return err
+}
+
+func (r *FileResolver) loadGroups() error {
+	groups := make(map[int]*user.Group)
+	err := readColonFile(r.GroupPath, &r.groupTime, func(fields []string) {
+		// name:password:gid:members
+		if len(fields) < 3 {
+			return
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		if _, found := groups[gid]; !found {
+			groups[gid] = &user.Group{Gid: fields[2], Name: fields[0]}
+		}
+	})
+	if err == nil && len(groups) > 0 {
+		r.groups = groups
+	}
+	return err
+}
+
+func (r *FileResolver) LookupUser(uid int) (*user.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.loadUsers(); err != nil {
+		return nil, err
+	}
+	if u, found := r.users[uid]; found {
+		result := *u
+		return &result, nil
+	}
+	return nil, user.UnknownUserIdError(uid)
+}
+
+func (r *FileResolver) LookupGroup(gid int) (*user.Group, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.loadGroups(); err != nil {
+		return nil, err
+	}
+	if g, found := r.groups[gid]; found {
+		result := *g
+		return &result, nil
+	}
+	return nil, user.UnknownGroupIdError(strconv.Itoa(gid))
+}
+
+type cachedUser struct {
+	user    *user.User
+	err     error
+	expires time.Time
+}
+
+type cachedGroup struct {
+	group   *user.Group
+	err     error
+	expires time.Time
+}
+
+// CachedResolver keeps the results of another UserResolver, including
+// failed lookups, for TTL.
+type CachedResolver struct {
+	Resolver UserResolver
+	TTL      time.Duration
+	mutex    sync.Mutex
+	users    map[int]cachedUser
+	groups   map[int]cachedGroup
+}
+
+// NewCachedResolver returns a CachedResolver for r.
+func NewCachedResolver(r UserResolver, ttl time.Duration) *CachedResolver {
+	return &CachedResolver{
+		Resolver: r,
+		TTL:      ttl,
+		users:    make(map[int]cachedUser),
+		groups:   make(map[int]cachedGroup),
+	}
+}
+
+func (r *CachedResolver) LookupUser(uid int) (*user.User, error) {
+	now := time.Now()
+	r.mutex.Lock()
+	entry, found := r.users[uid]
+	r.mutex.Unlock()
+	if !found || now.After(entry.expires) {
+		entry.user, entry.err = r.Resolver.LookupUser(uid)
+		entry.expires = now.Add(r.TTL)
+		r.mutex.Lock()
+		r.users[uid] = entry
+		r.mutex.Unlock()
+	}
+	return entry.user, entry.err
+}
+
+func (r *CachedResolver) LookupGroup(gid int) (*user.Group, error) {
+	now := time.Now()
+	r.mutex.Lock()
+	entry, found := r.groups[gid]
+	r.mutex.Unlock()
+	if !found || now.After(entry.expires) {
+		entry.group, entry.err = r.Resolver.LookupGroup(gid)
+		entry.expires = now.Add(r.TTL)
+		r.mutex.Lock()
+		r.groups[gid] = entry
+		r.mutex.Unlock()
+	}
+	return entry.group, entry.err
+}
+
+// Flush removes all the cached entries.
+func (r *CachedResolver) Flush() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.users = make(map[int]cachedUser)
+	r.groups = make(map[int]cachedGroup)
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,158 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	dir := t.TempDir()
+	r := &FileResolver{
+		PasswdPath: filepath.Join(dir, "passwd"),
+		GroupPath:  filepath.Join(dir, "group"),
+	}
+	mtime := time.Now().Add(-time.Hour)
+	writeFile(t, r.PasswdPath, `# users
+root:x:0:0:root:/root:/bin/bash
+alice:x:1000:1000:Alice Liddell,,,:/home/alice:/bin/sh
+alias:x:1000:1000::/home/alias:/bin/sh
+broken:x:1001
+bad:x:uid:0::/:/bin/sh
+`, mtime)
+	writeFile(t, r.GroupPath, `root:x:0:
+users:x:1000:alice
+dup:x:1000:
+`, mtime)
+
+	for _, tt := range []struct {
+		uid  int
+		want user.User
+	}{
+		{0, user.User{Uid: "0", Gid: "0", Username: "root", Name: "root", HomeDir: "/root"}},
+		// the first entry wins
+		{1000, user.User{Uid: "1000", Gid: "1000", Username: "alice", Name: "Alice Liddell", HomeDir: "/home/alice"}},
+	} {
+		u, err := r.LookupUser(tt.uid)
+		if err != nil {
+			t.Errorf("LookupUser(%d): %v", tt.uid, err)
+		} else if *u != tt.want {
+			t.Errorf("LookupUser(%d) returned %+v, want %+v", tt.uid, *u, tt.want)
+		}
+	}
+	if g, err := r.LookupGroup(1000); err != nil || g.Name != "users" {
+		t.Errorf("LookupGroup(1000) returned %v, %v", g, err)
+	}
+	var unknown user.UnknownUserIdError
+	if _, err := r.LookupUser(1001); !errors.As(err, &unknown) || int(unknown) != 1001 {
+		t.Errorf("LookupUser(1001) returned %v, want UnknownUserIdError", err)
+	}
+	var unknownGroup user.UnknownGroupIdError
+	if _, err := r.LookupGroup(2000); !errors.As(err, &unknownGroup) {
+		t.Errorf("LookupGroup(2000) returned %v, want UnknownGroupIdError", err)
+	}
+
+	// reloaded once modified
+	writeFile(t, r.PasswdPath, "bob:x:1001:1001::/home/bob:/bin/sh\n", mtime.Add(time.Minute))
+	if u, err := r.LookupUser(1001); err != nil || u.Username != "bob" {
+		t.Errorf("LookupUser(1001) after reload returned %v, %v", u, err)
+	}
+	if _, err := r.LookupUser(0); !errors.As(err, &unknown) {
+		t.Errorf("LookupUser(0) after reload returned %v, want UnknownUserIdError", err)
+	}
+	// not reloaded while unmodified
+	writeFile(t, r.PasswdPath, "carol:x:1001:1001::/home/carol:/bin/sh\n", mtime.Add(time.Minute))
+	if u, err := r.LookupUser(1001); err != nil || u.Username != "bob" {
+		t.Errorf("LookupUser(1001) returned %v, %v, want the former entry", u, err)
+	}
+	os.Remove(r.PasswdPath)
+	if _, err := r.LookupUser(1001); !os.IsNotExist(err) {
+		t.Errorf("LookupUser(1001) without file returned %v", err)
+	}
+}
+
+// countingResolver counts the lookups, failing for negative ids.
+type countingResolver struct {
+	users, groups int
+}
+
+func (r *countingResolver) LookupUser(uid int) (*user.User, error) {
+	r.users++
+	if uid < 0 {
+		return nil, user.UnknownUserIdError(uid)
+	}
+	return &user.User{Uid: strconv.Itoa(uid), Username: "u" + strconv.Itoa(uid)}, nil
+}
+
+func (r *countingResolver) LookupGroup(gid int) (*user.Group, error) {
+	r.groups++
+	return &user.Group{Gid: strconv.Itoa(gid), Name: "g" + strconv.Itoa(gid)}, nil
+}
+
+func TestCachedResolver(t *testing.T) {
+	stub := &countingResolver{}
+	r := NewCachedResolver(stub, time.Hour)
+	for i := 0; i < 3; i++ {
+		if u, err := r.LookupUser(1000); err != nil || u.Username != "u1000" {
+			t.Fatalf("LookupUser(1000) returned %v, %v", u, err)
+		}
+		// failures are cached too
+		if _, err := r.LookupUser(-1); err == nil {
+			t.Fatal("LookupUser(-1) succeeded")
+		}
+		r.LookupGroup(100)
+	}
+	if stub.users != 2 || stub.groups != 1 {
+		t.Errorf("got %d user and %d group lookups, want 2 and 1", stub.users, stub.groups)
+	}
+	r.Flush()
+	r.LookupUser(1000)
+	r.LookupGroup(100)
+	if stub.users != 3 || stub.groups != 2 {
+		t.Errorf("got %d user and %d group lookups after Flush, want 3 and 2", stub.users, stub.groups)
+	}
+
+	// entries expire at once
+	stub = &countingResolver{}
+	r = NewCachedResolver(stub, 0)
+	for i := 0; i < 3; i++ {
+		r.LookupUser(1000)
+		r.LookupGroup(100)
+		time.Sleep(time.Millisecond)
+	}
+	if stub.users != 3 || stub.groups != 3 {
+		t.Errorf("got %d user and %d group lookups, want 3 each", stub.users, stub.groups)
+	}
+}
@@ -0,0 +1,99 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"context"
+	"sync"
+)
+
+// StreamProcs returns a channel delivering filtered processes as soon as
+// workers read them, in no particular order. The channel is closed when all
+// processes have been read or when ctx is done.
+func StreamProcs(ctx context.Context, filter Filterer, options ...ScanOption) <-chan *Proc {
+	opts := getScanOptions(options)
+	pids, _ := ListPids()
+	// make our channels for communicating work and results
+	stats := make(chan string, opts.workers)
+	procs := make(chan *Proc, opts.workers)
+	// spin up workers and use a sync.WaitGroup to indicate completion
+	var wg sync.WaitGroup
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var p *Proc
+			var err error
+			for stat := range stats {
+				p, err = NewProcFromStat(stat, opts.load)
+				if !filter.Filter(p, err) {
+					continue
+				}
+				select {
+				case procs <- p:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	// start sending jobs
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(stats)
+		for _, pid := range pids {
+			data, err := ReadStat(pid)
+			if err != nil {
+				continue
+			}
+			select {
+			case stats <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	// wait on the workers to finish and close the procs channel
+	// to signal downstream that all work is done
+	go func() {
+		defer close(procs)
+		wg.Wait()
+	}()
+	return procs
+}
+
+// WalkProcs calls fn for each filtered process, in no particular order. It
+// stops at the first error returned by fn, or when ctx is done, and returns
+// that error.
+func WalkProcs(ctx context.Context, filter Filterer, fn func(p *Proc) error, options ...ScanOption) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for p := range StreamProcs(ctx, filter, options...) {
+		if err = fn(p); err != nil {
+			return
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+	}
+	return ctx.Err()
+}
+
+// vim: set ft=go fdm=indent ts=2 sw=2 tw=79 noet:
@@ -0,0 +1,109 @@
+// build +linux
+
+/*
+Copyright © 2022 David Guadalupe <guadalupe.david@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package goprocfs
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// checkGoroutines fails unless the number of goroutines gets back to
+// baseline shortly.
+func checkGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Errorf("%d goroutines left, want %d", runtime.NumGoroutine(), baseline)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func enoughProcs(t *testing.T, count int) {
+	t.Helper()
+	if pids, err := ListPids(); err != nil || len(pids) < count {
+		t.Skipf("fewer than %d processes: %v", count, err)
+	}
+}
+
+func TestWalkProcsError(t *testing.T) {
+	enoughProcs(t, 8)
+	baseline := runtime.NumGoroutine()
+	errStop := errors.New("stop")
+	var calls int
+	err := WalkProcs(context.Background(), GetFilterer("all"), func(p *Proc) error {
+		if calls++; calls == 3 {
+			return errStop
+		}
+		return nil
+	}, Workers(4), Load(LoadStat))
+	if err != errStop {
+		t.Errorf("WalkProcs returned %v, want %v", err, errStop)
+	}
+	if calls != 3 {
+		t.Errorf("fn called %d times, want 3", calls)
+	}
+	checkGoroutines(t, baseline)
+}
+
+func TestWalkProcsCancel(t *testing.T) {
+	enoughProcs(t, 8)
+	baseline := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	err := WalkProcs(ctx, GetFilterer("all"), func(p *Proc) error {
+		if calls++; calls == 2 {
+			cancel()
+		}
+		return nil
+	}, Workers(4), Load(LoadStat))
+	if err != context.Canceled || calls != 2 {
+		t.Errorf("WalkProcs returned %v after %d calls, want %v after 2", err, calls, context.Canceled)
+	}
+	checkGoroutines(t, baseline)
+}
+
+func TestStreamProcsCancel(t *testing.T) {
+	enoughProcs(t, 8)
+	baseline := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// read a single process, leaving the workers blocked on sending
+	procs := StreamProcs(ctx, GetFilterer("all"), Workers(2), Load(LoadStat))
+	if _, ok := <-procs; !ok {
+		t.Fatal("no process streamed")
+	}
+	cancel()
+	// the channel closes, possibly after a few processes already read
+	timeout := time.After(5 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-procs:
+			closed = !ok
+		case <-timeout:
+			t.Fatal("channel not closed after cancellation")
+		}
+	}
+	checkGoroutines(t, baseline)
+}